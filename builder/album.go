@@ -1,8 +1,7 @@
-package main
+package builder
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"path"
 	"strings"
@@ -10,15 +9,15 @@ import (
 	"github.com/aamcrae/pweb/shared"
 )
 
-// UpdateAlbum will read the album metadata file that references this
+// updateAlbum will read the album metadata file that references this
 // gallery, and will add or update it if there is no matching entry.
 // If reverse is set, then the entry will be added at the end
-func UpdateAlbum(back, dest, dir, title string, reverse bool) error {
+func (b *build) updateAlbum(back, dest, dir, title string, reverse bool) error {
 	// Map to metadata file from back href.
 	albumDir := path.Dir(path.Join(dest, dir, back))
 	album := path.Join(albumDir, shared.AlbumFileMeta)
 	// Whatever happens with the album file, make sure that the album HTML is up to date.
-	cpFile(path.Join(*assets, "index.html"), path.Join(albumDir, "index.html"))
+	cpFile(path.Join(b.opts.Assets, "index.html"), path.Join(albumDir, "index.html"))
 	// The back reference (usually "../index.html") may actually refer
 	// back to deeper levels, so to create the link forward, copy as
 	// many directory elements as necessary from the destination directory
@@ -39,9 +38,7 @@ func UpdateAlbum(back, dest, dir, title string, reverse bool) error {
 				// Gallery reference already exists, check that the data is the same,
 				// otherwise rewrite it.
 				if link == al.Link && al.Title == title {
-					if *verbose {
-						fmt.Printf("Gallery %s already present in %s\n", dir, album)
-					}
+					b.verbosef("Gallery %s already present in %s\n", dir, album)
 					return nil
 				}
 				adata.Albums[ind].Link = link
@@ -50,16 +47,16 @@ func UpdateAlbum(back, dest, dir, title string, reverse bool) error {
 				break
 			}
 		}
-		if *verbose && !exists {
-			fmt.Printf("Gallery %s being added to %s\n", dir, album)
+		if !exists {
+			b.verbosef("Gallery %s being added to %s\n", dir, album)
 		}
 	} else if errors.Is(err, os.ErrNotExist) {
 		if err := os.MkdirAll(path.Dir(album), 0755); err != nil {
 			return err
 		}
-		fmt.Printf("%s: New album, please set title etc.", album)
+		b.statusf("%s: New album, please set title etc.\n", album)
 		// Preload album data from template
-		if err := readMeta(path.Join(*assets, shared.TemplateAlbumFileMeta), &adata); err != nil {
+		if err := readMeta(path.Join(b.opts.Assets, shared.TemplateAlbumFileMeta), &adata); err != nil {
 			return err
 		}
 	} else {
@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"context"
+	"image"
+	_ "image/jpeg"
+	"os"
+	"sync"
+)
+
+// Pixel count used to estimate the memory of an image if the dimensions are unknown.
+const defaultPixels = 24_000_000
+
+// memoryBudget limits the estimated memory used by the images being
+// processed concurrently. Tasks are admitted in order, so that a large image
+// is not starved by a stream of small images.
+// A nil budget is valid, and does not limit the memory.
+type memoryBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	used    int64
+	waiters []*budgetWaiter
+}
+
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// newMemoryBudget creates a budget of limit bytes, or returns nil if limit is 0.
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &memoryBudget{limit: limit}
+}
+
+// acquire waits until n bytes are available in the budget, or the context is cancelled.
+// A request larger than the budget waits until no other images are being processed.
+// The amount acquired is returned, which must be passed to release.
+func (m *memoryBudget) acquire(ctx context.Context, n int64) (int64, error) {
+	if m == nil || n <= 0 {
+		return 0, nil
+	}
+	n = min(n, m.limit)
+	m.mu.Lock()
+	if len(m.waiters) == 0 && m.used+n <= m.limit {
+		m.used += n
+		m.mu.Unlock()
+		return n, nil
+	}
+	w := &budgetWaiter{n: n, ready: make(chan struct{})}
+	m.waiters = append(m.waiters, w)
+	m.mu.Unlock()
+	select {
+	case <-w.ready:
+		return n, nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Acquired while being cancelled, so return it.
+			m.used -= n
+		default:
+			for i, wt := range m.waiters {
+				if wt == w {
+					m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		m.admit()
+		return 0, ctx.Err()
+	}
+}
+
+// release returns the bytes to the budget, and admits any waiting requests that now fit.
+func (m *memoryBudget) release(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used -= n
+	m.admit()
+}
+
+// admit wakes the waiting requests in order while they fit in the budget.
+// Must be called with the lock held.
+func (m *memoryBudget) admit() {
+	for len(m.waiters) > 0 && m.used+m.waiters[0].n <= m.limit {
+		w := m.waiters[0]
+		m.waiters = m.waiters[1:]
+		m.used += w.n
+		close(w.ready)
+	}
+}
+
+// imageMemory estimates the memory required to process the picture, using the
+// dimensions from the EXIF data or a previous build, or by reading the image header.
+func (p *Pict) imageMemory(exif *Exif) int64 {
+	w, h := exif.width, exif.height
+	if w == 0 || h == 0 {
+		w, h = p.width, p.height
+	}
+	if w == 0 || h == 0 {
+		if f, err := os.Open(p.srcPath); err == nil {
+			if c, _, err := image.DecodeConfig(f); err == nil {
+				w, h = c.Width, c.Height
+			}
+			f.Close()
+		}
+	}
+	pixels := int64(w) * int64(h)
+	if pixels == 0 {
+		pixels = defaultPixels
+	}
+	return pixels * int64(p.b.opts.Imager.BytesPerPixel)
+}
@@ -0,0 +1,761 @@
+// Package builder generates a web gallery from a set of photos, writing the scaled images
+// and the gallery metadata, and adding the gallery to the album that references it.
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aamcrae/pweb/imager"
+	"github.com/aamcrae/pweb/shared"
+)
+
+const (
+	previewWidth  = 320
+	previewHeight = 240
+)
+
+// Prefix of the temporary files that images are written to.
+const tmpPrefix = ".pweb-tmp-"
+
+const (
+	DL_NONE = iota
+	DL_SYMLINK
+	DL_STATIC
+)
+
+// Sort keys, selecting the order of the photos.
+const (
+	SORT_NONE    = iota
+	SORT_NAME    // Filename
+	SORT_DATE    // Capture time, with the sub-second time and sequence number breaking ties
+	SORT_NATURAL // Filename, with numbers compared by value (IMG_2 before IMG_10)
+	SORT_RATING  // XMP Rating, with unrated photos as 0
+	SORT_TITLE   // Title, without regard to case
+	SORT_SHUFFLE // Random order, repeatable using the seed
+)
+
+// Error policies, selecting how errors processing an image are handled.
+const (
+	ONERROR_ABORT = iota // Stop the build on the first error
+	ONERROR_SKIP         // Skip the image, and continue the build
+)
+
+// rScaleMap maps a selected rating to photo ratings that will be accepted
+// e.g a rating of '3' will select photos with a rating of '3', '4' and '5'.
+var rScaleMap = map[string][]string{
+	"0": {"0", "1", "2", "3", "4", "5"},
+	"1": {"1", "2", "3", "4", "5"},
+	"2": {"2", "3", "4", "5"},
+	"3": {"3", "4", "5"},
+	"4": {"4", "5"},
+	"5": {"5"},
+}
+
+// Options controls how galleries are built.
+type Options struct {
+	BaseDir   string        // Base directory of web pages
+	Assets    string        // Source directory of web assets
+	Force     bool          // Remove the gallery completely and rebuild it
+	Verbose   bool          // Verbose output
+	Progress  bool          // Display progress bars and status messages
+	Timeout   time.Duration // Timeout of processing each image, or 0 for no timeout
+	Workers   int           // Maximum number of images processed concurrently, or 0 for the number of CPUs
+	MaxMemory int64         // Limit of the estimated memory of the images processed concurrently, or 0 for no limit
+	Imager    *Imager       // Image processor, the default is "dis"
+	Metadata  *Metadata     // Metadata reader, the default is "exiv2"
+	Cache     *ExifCache    // EXIF cache, or nil if no cache is used
+	OnError   int           // Error policy (ONERROR_ABORT or ONERROR_SKIP)
+	// Read images at a reduced resolution (e.g from the embedded EXIF thumbnail) when
+	// only the smaller images need to be generated.
+	FastThumbs bool
+}
+
+// Report summarises the gallery that was built.
+type Report struct {
+	Dir     string  // Gallery directory
+	Photos  int     // Number of photos in the gallery
+	Resized int     // Number of photos where images were generated
+	Images  int     // Number of image files written
+	Skipped []error // Errors of the pictures that have been skipped
+}
+
+// build holds the state of a single gallery build.
+type build struct {
+	ctx     context.Context
+	conf    *GalleryConfig
+	opts    Options
+	srcDir  string
+	destDir string
+	reader  string // Identifies the metadata reader and sidecar mode in the EXIF cache
+	skipped []error
+	budget  *memoryBudget
+	dirs    map[string][]string // Directory listings used to find paired files
+	fields  []metaField         // Additional metadata fields used in templates
+	photos  photoList           // Photo list read from the manifest file, if any
+
+	title         *template            // Gallery title
+	captionFormat *template            // Template of the titles of photos without a title, if set
+	captions      map[string]*template // Titles from the config file, keyed by filename
+	sources       []CaptionSource      // Sources of the titles and captions
+	filters       []picFilter          // Filters selecting the pictures
+}
+
+// Build generates or updates the gallery described by the configuration.
+func Build(ctx context.Context, conf *GalleryConfig, opts Options) (*Report, error) {
+	b, err := newBuild(ctx, conf, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Additional image formats to be generated. JPEG is always generated as the fallback.
+	var formats []imager.Format
+	for _, f := range conf.Formats {
+		if !slices.Contains(b.opts.Imager.Formats, f) {
+			return nil, &ConfigError{Keyword: "format", Err: fmt.Errorf("image format %s not supported by %s imager", f, b.opts.Imager.Name)}
+		}
+		if f == imager.WebP && b.opts.Imager.Name == "dis" {
+			b.warnf("Warning: the dis imager writes lossless WebP images, which are usually larger than JPEG")
+		}
+		if f != imager.JPEG && !slices.Contains(formats, f) {
+			formats = append(formats, f)
+		}
+	}
+	// Order the formats by preference (smallest files first).
+	slices.SortFunc(formats, func(a, b imager.Format) int {
+		return int(b) - int(a)
+	})
+	picts, err := b.selectPicts(false)
+	if err != nil {
+		return nil, err
+	}
+	galleryTitle := b.title.expand(func(name, format string) string {
+		return galleryValue(picts, name, format)
+	})
+	if b.title.isTemplate() {
+		b.verbosef("Gallery title is <%s>\n", galleryTitle)
+	}
+	destDir := b.destDir
+	// If force is on, delete the entire destination directory
+	if b.opts.Force {
+		if err := os.RemoveAll(destDir); err != nil {
+			return nil, err
+		}
+	} else {
+		// Remove any images no longer wanted
+		if err := removeUnwanted(destDir, picts, formats); err != nil {
+			return nil, err
+		}
+	}
+	imageWidth, imageHeight := 1500, 1200
+	if conf.Large {
+		imageWidth = 1800
+		imageHeight = 1500
+	}
+	thumbWidth, thumbHeight := conf.Thumb, conf.Thumb
+	// Build the list of scaled images to be generated, from largest to smallest.
+	var sizes []int
+	for _, w := range conf.Widths {
+		if w < imageWidth && !slices.Contains(sizes, w) {
+			sizes = append(sizes, w)
+		}
+	}
+	slices.Sort(sizes)
+	// The images displayed in the gallery are also generated in the additional formats.
+	var renditions []rendition
+	add := func(dir string, w, h, q int, displayed bool) {
+		renditions = append(renditions, rendition{dir: dir, width: w, height: h, quality: q})
+		if displayed {
+			for _, f := range formats {
+				renditions = append(renditions, rendition{dir: dir, width: w, height: h, quality: q, format: f})
+			}
+		}
+	}
+	add("", imageWidth, imageHeight, 90, true)
+	for i := len(sizes) - 1; i >= 0; i-- {
+		add(shared.SizeDir(sizes[i]), sizes[i], sizes[i]*imageHeight/imageWidth, 85, true)
+	}
+	add(shared.PreviewDir, previewWidth, previewHeight, 80, false)
+	add(shared.Thumb2xDir, thumbWidth*2, thumbHeight*2, 80, true)
+	add(shared.ThumbDir, thumbWidth, thumbHeight, 80, true)
+	if conf.Up != "" {
+		if err := b.updateAlbum(conf.Up, b.opts.BaseDir, conf.Dir, galleryTitle, conf.Reverse); err != nil {
+			return nil, fmt.Errorf("update album: %w", err)
+		}
+	}
+	download := conf.Download
+	zipped := download != DL_NONE && !conf.NoZip
+	// Ensure base page, scaled image and (optionally) download directories exist.
+	if err := makeDirs(destDir); err != nil {
+		return nil, err
+	}
+	for _, r := range renditions {
+		if err := makeDirs(path.Join(destDir, r.dir)); err != nil {
+			return nil, err
+		}
+	}
+	b.removeSizes(destDir, sizes)
+	removeTemp(destDir)
+	dlDir := path.Join(destDir, shared.DownloadDir)
+	if download == DL_NONE {
+		// Remove any download directory
+		os.RemoveAll(dlDir)
+	} else {
+		if err := makeDirs(dlDir); err != nil {
+			return nil, err
+		}
+		// If there is a .htaccess file required, copy it.
+		if err := cpMaybe(path.Join(b.opts.Assets, "download-htaccess"), path.Join(dlDir, ".htaccess")); err != nil {
+			return nil, fmt.Errorf("write htaccess: %w", err)
+		}
+	}
+	var g shared.Gallery
+	// Preload gallery from template (to set copyright etc.)
+	readMeta(path.Join(b.opts.Assets, shared.TemplateGalleryFileMeta), &g)
+	g.Title = galleryTitle
+	g.NoThumbCaptions = conf.NoCaption == CAPTION_HIDE_THUMBS
+	if zipped {
+		g.Download = path.Join(shared.DownloadDir, zipFile)
+	}
+	if conf.Up != "" {
+		g.Back = conf.Up
+	}
+	g.Thumb.Width = thumbWidth
+	g.Thumb.Height = thumbHeight
+	g.Thumb2x.Width = thumbWidth * 2
+	g.Thumb2x.Height = thumbHeight * 2
+	g.Preview.Width = previewWidth
+	g.Preview.Height = previewHeight
+	g.Image.Width = imageWidth
+	g.Image.Height = imageHeight
+	g.Sizes = sizes
+	for _, f := range formats {
+		g.Formats = append(g.Formats, f.String())
+	}
+	// Now generate the scaled images that will appear on the web site.
+	manifest := readManifest(destDir, b.opts.Imager.Name)
+	report := &Report{Dir: destDir}
+	picts, report.Resized, report.Images, err = b.resizePhotos(picts, renditions, manifest)
+	// Save the manifest even if the build failed, so that completed images are not rebuilt.
+	if werr := manifest.write(); werr != nil && err == nil {
+		err = fmt.Errorf("%s: %w", manifestFile, werr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Photos = len(picts)
+	report.Skipped = b.skipped
+	// Add the images to the gallery - this is done after the
+	// resize in order to capture the original resolution dimensions, which is
+	// only known after the image is processed.
+	// Pictures that have failed are not included.
+	for _, p := range picts {
+		if err := p.AddToGallery(&g, download); err != nil {
+			return nil, err
+		}
+	}
+	// Write the gallery file
+	gFile := path.Join(destDir, shared.GalleryFileMeta)
+	if err := writeMeta(gFile, &g); err != nil {
+		return nil, fmt.Errorf("%s: %w", gFile, err)
+	}
+	if zipped {
+		if err := b.updateZip(dlDir, picts, conf.ZipStore); err != nil {
+			return nil, fmt.Errorf("update zip: %w", err)
+		}
+	} else {
+		os.Remove(path.Join(dlDir, zipFile))
+	}
+	// Conditionally copy the main index.html file.
+	if err := cpFile(path.Join(b.opts.Assets, "index.html"), path.Join(destDir, "index.html")); err != nil {
+		return nil, fmt.Errorf("index.html: update %w", err)
+	}
+	return report, nil
+}
+
+// selectFiles builds the list of candidate source files from the include, exclude,
+// after and before configuration, with any raw files paired with images removed.
+func (b *build) selectFiles() ([]string, error) {
+	conf := b.conf
+	files, err := globFiles(b.srcDir, conf.Include, conf.NoCase)
+	if err != nil {
+		return nil, &ConfigError{Keyword: "include", Err: err}
+	}
+	b.verbosef("Include list: %v\n", files)
+	if len(conf.Exclude) > 0 {
+		fl, err := globFiles(b.srcDir, conf.Exclude, conf.NoCase)
+		if err != nil {
+			return nil, &ConfigError{Keyword: "exclude", Err: err}
+		}
+		for _, ex := range fl {
+			if ind, ok := find(files, ex); ok {
+				files = append(files[:ind], files[ind+1:]...)
+			} else {
+				b.warnf("Cannot find %s in file list, ignored", ex)
+			}
+		}
+	}
+	if len(conf.After) > 0 {
+		if files, err = insert(b.srcDir, files, conf.After, false, conf.NoCase); err != nil {
+			return nil, &ConfigError{Keyword: "after", Err: err}
+		}
+	}
+	if len(conf.Before) > 0 {
+		if files, err = insert(b.srcDir, files, conf.Before, true, conf.NoCase); err != nil {
+			return nil, &ConfigError{Keyword: "before", Err: err}
+		}
+	}
+	if conf.Pair != PAIR_NONE {
+		files = b.removePaired(files)
+	}
+	return files, nil
+}
+
+// readPicts will create a photo object and optionally read the EXIF (if the EXIF
+// data is required for further processing). Pictures that cannot be read are
+// handled according to the error policy.
+func (b *build) readPicts(files []string, exifRequired bool) ([]*Pict, error) {
+	// Create a worker pool to read the EXIF data
+	var unratedPicts []*Pict
+	// The names are assigned before any images are written, so that colliding names are made unique.
+	names := outputNames(files)
+	pWork := b.newWorker("Reading ", len(files))
+	for _, f := range files {
+		p, err := newPict(b, f, names[f])
+		if err != nil {
+			err = &ImageError{File: f, Op: "read", Err: err}
+			if b.opts.OnError == ONERROR_ABORT {
+				pWork.Wait()
+				return nil, err
+			}
+			b.skipped = append(b.skipped, err)
+			continue
+		}
+		unratedPicts = append(unratedPicts, p)
+		// Read the EXIF if required
+		if exifRequired {
+			pWork.Run(p.srcFile, func(ctx context.Context) error {
+				if b.stopped(pWork) {
+					return nil
+				}
+				_, err := p.GetExif()
+				return err
+			})
+		}
+	}
+	pWork.Wait()
+	return b.checkErrors(unratedPicts, pWork)
+}
+
+// filterPicts removes the pictures that are not selected by all the filters.
+func (b *build) filterPicts(inPicts []*Pict) []*Pict {
+	var outPicts []*Pict
+	for _, p := range inPicts {
+		selected := true
+		for _, f := range b.filters {
+			if ok, v := f.match(p); !ok {
+				b.verbosef("%s: Skipping due to %s (%s)\n", p.srcFile, f.name, v)
+				selected = false
+				break
+			}
+		}
+		if selected {
+			outPicts = append(outPicts, p)
+		}
+	}
+	return outPicts
+}
+
+// addFields adds the metadata fields (e.g used by a template) to the fields read with the EXIF data.
+func (b *build) addFields(fields []metaField) {
+	for _, f := range fields {
+		if !slices.ContainsFunc(b.fields, func(bf metaField) bool { return bf.name == f.name }) {
+			b.fields = append(b.fields, f)
+		}
+	}
+	slices.SortFunc(b.fields, func(a, b metaField) int {
+		return strings.Compare(a.name, b.name)
+	})
+}
+
+// newBuild checks the configuration and options, and sets up the build of the gallery.
+func newBuild(ctx context.Context, conf *GalleryConfig, opts Options) (*build, error) {
+	b := &build{ctx: ctx, conf: conf, opts: opts, dirs: make(map[string][]string)}
+	var err error
+	if b.opts.Imager == nil {
+		if b.opts.Imager, err = SelectImager("dis"); err != nil {
+			return nil, err
+		}
+	}
+	if b.opts.Metadata == nil {
+		if b.opts.Metadata, err = SelectMetadata("exiv2"); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Dir == "" {
+		return nil, &ConfigError{Keyword: "dir", Err: errors.New("missing keyword")}
+	}
+	b.destDir = path.Join(opts.BaseDir, conf.Dir)
+	b.verbosef("Directory set to %s\n", b.destDir)
+	if b.srcDir, err = filepath.Abs(conf.SrcDir); err != nil {
+		return nil, err
+	}
+	if b.title, err = parseTemplate(conf.Title, true); err != nil {
+		return nil, &ConfigError{Keyword: "title", Err: err}
+	}
+	if conf.CaptionFormat != "" {
+		if b.captionFormat, err = parseTemplate(conf.CaptionFormat, false); err != nil {
+			return nil, &ConfigError{Keyword: "caption-format", Err: err}
+		}
+	}
+	b.captions = make(map[string]*template)
+	for f, c := range conf.Captions {
+		if b.captions[f], err = parseTemplate(c, false); err != nil {
+			return nil, &ConfigError{Keyword: "caption", Err: fmt.Errorf("%s: %w", f, err)}
+		}
+	}
+	if b.filters, err = newFilters(conf); err != nil {
+		return nil, err
+	}
+	for _, f := range b.filters {
+		b.addFields(f.fields)
+	}
+	b.addFields(sortFields(conf.Sort))
+	b.sources = conf.CaptionSources
+	if len(b.sources) == 0 {
+		b.sources = defaultSources
+	}
+	b.addFields(b.title.fields())
+	if b.captionFormat != nil {
+		b.addFields(b.captionFormat.fields())
+	}
+	for _, t := range b.captions {
+		b.addFields(t.fields())
+	}
+	for _, cs := range b.sources {
+		b.addFields(cs.fields())
+	}
+	// The EXIF cache entries record the additional fields read.
+	b.reader = fmt.Sprintf("%s/%d", b.opts.Metadata.Name, conf.Sidecar)
+	for _, f := range b.fields {
+		b.reader += "/" + f.name
+	}
+	b.budget = newMemoryBudget(opts.MaxMemory)
+	if conf.Manifest != "" {
+		mf := conf.Manifest
+		if !filepath.IsAbs(mf) {
+			mf = filepath.Join(b.srcDir, mf)
+		}
+		if b.photos, err = readPhotoList(mf); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// selectPicts selects the pictures of the gallery, reading the EXIF data if it is required
+// (or if readExif is set), and sets the picture titles and the order of the pictures.
+func (b *build) selectPicts(readExif bool) ([]*Pict, error) {
+	conf := b.conf
+	files, err := b.selectFiles()
+	if err != nil {
+		return nil, err
+	}
+	if b.photos != nil {
+		files = b.photos.apply(b, files)
+	}
+	b.verbosef("Before ratings and sorting: %v\n", files)
+	// The titles are set from the caption sources when the EXIF data is read, unless
+	// only the IPTC titles are used.
+	setCaptions := conf.NoCaption != CAPTION_HIDE && (len(b.captions) > 0 || b.photos.hasTitles() || !slices.Equal(b.sources, defaultSources))
+	exifRequired := readExif || len(b.filters) > 0 || sortExif(conf.Sort) || setCaptions ||
+		b.title.isTemplate() || b.captionFormat != nil
+	picts, err := b.readPicts(files, exifRequired)
+	if err != nil {
+		return nil, err
+	}
+	return b.orderPicts(picts, setCaptions || readExif), nil
+}
+
+// orderPicts sets the titles (if setCaptions is set), filters the pictures, and sorts the pictures.
+// The titles are set first, so that filters on the title or caption match the titles shown in the gallery.
+// The EXIF data has been read if it is required for selection, captions, templates or sorting.
+func (b *build) orderPicts(picts []*Pict, setCaptions bool) []*Pict {
+	conf := b.conf
+	b.photos.setRatings(picts)
+	if setCaptions {
+		b.setCaptions(picts)
+	}
+	if b.captionFormat != nil && conf.NoCaption != CAPTION_HIDE {
+		// Compose the titles of the pictures that do not have one.
+		for _, p := range picts {
+			if p.exif.title == "" {
+				p.exif.title = b.captionFormat.expand(p.photoValue)
+			}
+		}
+	}
+	if len(b.filters) > 0 {
+		picts = b.filterPicts(picts)
+	}
+	if len(conf.Sort) > 0 {
+		slices.SortStableFunc(picts, func(a, b *Pict) int {
+			return comparePicts(conf.Sort, a, b)
+		})
+	}
+	if b.opts.Verbose {
+		fmt.Printf("Final list:")
+		for _, p := range picts {
+			fmt.Printf(" %s", p.srcFile)
+		}
+		fmt.Printf("\n")
+	}
+	return picts
+}
+
+// resizePhotos generates the scaled images of the pictures, and the download files.
+// The pictures successfully processed, the number of pictures resized, and the number
+// of images written are returned.
+func (b *build) resizePhotos(picts []*Pict, renditions []rendition, m *buildManifest) ([]*Pict, int, int, error) {
+	var mu sync.Mutex
+	resized, images := 0, 0
+	resizers := b.newWorker("Resizing", len(picts))
+	for _, p := range picts {
+		cost := func() int64 {
+			return p.memoryCost(renditions, m)
+		}
+		resizers.RunWeighted(p.srcFile, cost, func(ctx context.Context) error {
+			if b.stopped(resizers) {
+				return nil
+			}
+			n, err := p.Resize(ctx, renditions, m)
+			if err == nil {
+				err = b.download(p)
+			}
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				mu.Lock()
+				resized++
+				images += n
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	resizers.Wait()
+	picts, err := b.checkErrors(picts, resizers)
+	return picts, resized, images, err
+}
+
+// stopped returns true if no further tasks should be run on the worker pool, either
+// because the build is cancelled, or a task has failed and the error policy is to abort.
+func (b *build) stopped(w *Worker) bool {
+	return b.ctx.Err() != nil || (b.opts.OnError == ONERROR_ABORT && w.Failed())
+}
+
+// checkErrors checks the errors from the pictures processed on the worker pool.
+// If the build is cancelled, or the error policy is to abort and a picture has failed,
+// an error is returned. Otherwise the pictures that failed are recorded as
+// skipped, and the remaining pictures are returned.
+func (b *build) checkErrors(picts []*Pict, w *Worker) ([]*Pict, error) {
+	if err := b.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if errs := w.Errors(); len(errs) != 0 && b.opts.OnError == ONERROR_ABORT {
+		return nil, errs[0]
+	}
+	var ok []*Pict
+	for _, p := range picts {
+		if err := w.Err(p.srcFile); err != nil {
+			b.verbosef("%s: skipped: %v\n", p.srcFile, err)
+			b.skipped = append(b.skipped, err)
+		} else {
+			ok = append(ok, p)
+		}
+	}
+	return ok, nil
+}
+
+// download installs the original image in the download directory, either
+// as a copy or as a symlink.
+func (b *build) download(p *Pict) error {
+	if err := b.downloadFile(p, p.srcPath, p.dlFile); err != nil {
+		return err
+	}
+	for _, e := range p.extras {
+		if err := b.downloadFile(p, e.srcPath, e.dlFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadFile places the file into the download directory, as either
+// a copy or a symlink.
+func (b *build) downloadFile(p *Pict, srcPath, dlFile string) error {
+	dlPath := path.Join(p.destDir, dlFile)
+	switch b.conf.Download {
+	case DL_STATIC:
+		// If the existing file is a symlink, remove it.
+		if st, err := os.Lstat(dlPath); err == nil {
+			if (st.Mode() & os.ModeSymlink) != 0 {
+				if err := os.Remove(dlPath); err != nil {
+					return &ImageError{File: p.srcFile, Op: "remove dl static", Err: err}
+				}
+			}
+		}
+		// Copy the original into the download directory.
+		if err := cpFile(srcPath, dlPath); err != nil {
+			return &ImageError{File: p.srcFile, Op: "download copy", Err: err}
+		}
+	case DL_SYMLINK:
+		// If regular file, remove it.
+		if st, err := os.Lstat(dlPath); err == nil {
+			if (st.Mode() & os.ModeSymlink) == 0 {
+				if err := os.Remove(dlPath); err != nil {
+					return &ImageError{File: p.srcFile, Op: "remove dl symlink", Err: err}
+				}
+			}
+		}
+		// create symlink in the download directory to the original file, if not already existing
+		if _, err := os.Stat(dlPath); err != nil {
+			if err := os.Symlink(srcPath, dlPath); err != nil {
+				return &ImageError{File: p.srcFile, Op: "symlink", Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// removeUnwanted removes the images of pictures that are no longer in the gallery.
+func removeUnwanted(destDir string, plist []*Pict, formats []imager.Format) error {
+	files := make(map[string]struct{})
+	// Get the list of all files in the thumbnail directory, and
+	// add them to the map.
+	dentries, err := os.ReadDir(path.Join(destDir, shared.ThumbDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, d := range dentries {
+		if !d.IsDir() {
+			files[d.Name()] = struct{}{}
+		}
+	}
+	// Remove all pictures from the map that are going to be added,
+	// including the images in the additional formats.
+	for _, p := range plist {
+		delete(files, p.destFile)
+		for _, f := range formats {
+			delete(files, shared.FormatFile(p.destFile, f.String()))
+		}
+	}
+	// The entries remaining are unwanted, so remove them from the
+	// gallery directory and all of the subdirectories.
+	dirs := []string{destDir}
+	if dentries, err := os.ReadDir(destDir); err == nil {
+		for _, d := range dentries {
+			if d.IsDir() {
+				dirs = append(dirs, path.Join(destDir, d.Name()))
+			}
+		}
+	}
+	for k, _ := range files {
+		for _, d := range dirs {
+			os.Remove(path.Join(d, k))
+		}
+	}
+	// Remove the downloads that are no longer wanted (the download
+	// filename may differ from the image filename, and paired files may have been removed).
+	downloads := map[string]struct{}{zipFile: {}}
+	for _, p := range plist {
+		downloads[path.Base(p.dlFile)] = struct{}{}
+		for _, e := range p.extras {
+			downloads[path.Base(e.dlFile)] = struct{}{}
+		}
+	}
+	if dentries, err := os.ReadDir(path.Join(destDir, shared.DownloadDir)); err == nil {
+		for _, d := range dentries {
+			if _, ok := downloads[d.Name()]; !ok && !d.IsDir() && !strings.HasPrefix(d.Name(), ".") {
+				os.Remove(path.Join(destDir, shared.DownloadDir, d.Name()))
+			}
+		}
+	}
+	return nil
+}
+
+// removeTemp removes any temporary image files left in the gallery
+// directory and subdirectories by an interrupted build.
+func removeTemp(destDir string) {
+	dirs := []string{destDir}
+	if dentries, err := os.ReadDir(destDir); err == nil {
+		for _, d := range dentries {
+			if d.IsDir() {
+				dirs = append(dirs, path.Join(destDir, d.Name()))
+			}
+		}
+	}
+	for _, d := range dirs {
+		if tl, err := filepath.Glob(path.Join(d, tmpPrefix+"*")); err == nil {
+			for _, t := range tl {
+				os.Remove(t)
+			}
+		}
+	}
+}
+
+// removeSizes removes any directories of scaled images that
+// are not in the current list of image widths.
+func (b *build) removeSizes(destDir string, sizes []int) {
+	dentries, err := os.ReadDir(destDir)
+	if err != nil {
+		return
+	}
+	for _, d := range dentries {
+		var w int
+		if n, _ := fmt.Sscanf(d.Name(), "w%d", &w); n == 1 && d.IsDir() && d.Name() == shared.SizeDir(w) && !slices.Contains(sizes, w) {
+			b.verbosef("Removing %s\n", d.Name())
+			os.RemoveAll(path.Join(destDir, d.Name()))
+		}
+	}
+}
+
+// newWorker creates a worker pool, with a progress bar if enabled.
+func (b *build) newWorker(name string, count int) *Worker {
+	if !b.opts.Progress {
+		name = ""
+	}
+	w := NewWorker(b.ctx, b.opts.Workers, b.opts.Timeout, name, count)
+	w.budget = b.budget
+	return w
+}
+
+// verbosef prints the message if verbose output is enabled.
+func (b *build) verbosef(format string, a ...any) {
+	if b.opts.Verbose {
+		fmt.Printf(format, a...)
+	}
+}
+
+// statusf prints a status message if progress output is enabled.
+func (b *build) statusf(format string, a ...any) {
+	if b.opts.Progress || b.opts.Verbose {
+		fmt.Printf(format, a...)
+	}
+}
+
+// warnf logs a warning.
+func (b *build) warnf(format string, a ...any) {
+	log.Printf(format, a...)
+}
@@ -0,0 +1,232 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Name of the EXIF cache file in the user's cache directory.
+const cacheFile = "pweb/exif.json"
+
+// cacheVersion is incremented when the cached data changes, so that old caches are discarded.
+const cacheVersion = 3
+
+// cachedExif is the serialisable form of the Exif data.
+type cachedExif struct {
+	Title       string            `json:"title,omitempty"`
+	Caption     string            `json:"caption,omitempty"`
+	Orientation string            `json:"orientation,omitempty"`
+	Time        time.Time         `json:"time,omitzero"`
+	Rating      string            `json:"rating,omitempty"`
+	ISO         string            `json:"iso,omitempty"`
+	Exposure    string            `json:"exposure,omitempty"`
+	FStop       string            `json:"fstop,omitempty"`
+	FocalLength string            `json:"focal_len,omitempty"`
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+}
+
+// cacheEntry is the cached EXIF data of a single image, along with the
+// file identity used to check that the entry is still valid.
+type cacheEntry struct {
+	Size         int64      `json:"size"`
+	Mtime        time.Time  `json:"mtime"`
+	Sidecar      string     `json:"sidecar,omitempty"`
+	SidecarMtime time.Time  `json:"sidecar_mtime,omitzero"`
+	Reader       string     `json:"reader"` // Metadata reader and sidecar mode
+	Exif         cachedExif `json:"exif"`
+}
+
+// ExifCache is a persistent cache of the EXIF data read from images, keyed by the
+// full pathname of the image, so that unchanged images do not need to be re-read.
+// The cache is shared across all galleries. Galleries may read different metadata fields
+// from the same image, so an image has an entry for each metadata reader and set of fields.
+// A nil cache is valid, and never contains any entries.
+type ExifCache struct {
+	mu      sync.Mutex
+	file    string
+	changed bool
+	Version int                     `json:"version"`
+	Entries map[string][]cacheEntry `json:"entries"`
+}
+
+// OpenCache reads the EXIF cache from the user's cache directory.
+// If the cache cannot be read, an empty cache is returned.
+func OpenCache() (*ExifCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	c := &ExifCache{file: filepath.Join(dir, cacheFile)}
+	if err := readMeta(c.file, c); err != nil || c.Version != cacheVersion {
+		c.Version = cacheVersion
+		c.Entries = make(map[string][]cacheEntry)
+	}
+	return c, nil
+}
+
+// lookup returns the cached EXIF data for the picture, if the cache entry is still valid.
+// reader identifies the metadata reader and options, since different readers may produce different results.
+func (c *ExifCache) lookup(p *Pict, reader string) (*Exif, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	i := slices.IndexFunc(c.Entries[p.srcPath], func(e cacheEntry) bool { return e.Reader == reader })
+	var e cacheEntry
+	if i >= 0 {
+		e = c.Entries[p.srcPath][i]
+	}
+	c.mu.Unlock()
+	if i < 0 || !e.valid(p, reader) {
+		return nil, false
+	}
+	return &Exif{
+		title:       e.Exif.Title,
+		caption:     e.Exif.Caption,
+		orientation: e.Exif.Orientation,
+		ts:          e.Exif.Time,
+		rating:      e.Exif.Rating,
+		iso:         e.Exif.ISO,
+		exposure:    e.Exif.Exposure,
+		fstop:       e.Exif.FStop,
+		focal_len:   e.Exif.FocalLength,
+		width:       e.Exif.Width,
+		height:      e.Exif.Height,
+		fields:      e.Exif.Fields,
+	}, true
+}
+
+// add stores the EXIF data of the picture in the cache, replacing any entry for the same reader.
+func (c *ExifCache) add(p *Pict, reader string, exif *Exif) {
+	if c == nil {
+		return
+	}
+	e := newCacheEntry(p, reader, cachedExif{
+		Title:       exif.title,
+		Caption:     exif.caption,
+		Orientation: exif.orientation,
+		Time:        exif.ts,
+		Rating:      exif.rating,
+		ISO:         exif.iso,
+		Exposure:    exif.exposure,
+		FStop:       exif.fstop,
+		FocalLength: exif.focal_len,
+		Width:       exif.width,
+		Height:      exif.height,
+		Fields:      exif.fields,
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := c.Entries[p.srcPath]
+	if i := slices.IndexFunc(entries, func(e cacheEntry) bool { return e.Reader == reader }); i >= 0 {
+		entries[i] = e
+	} else {
+		c.Entries[p.srcPath] = append(entries, e)
+	}
+	c.changed = true
+}
+
+// newCacheEntry creates a cache entry for the picture.
+func newCacheEntry(p *Pict, reader string, exif cachedExif) cacheEntry {
+	return cacheEntry{
+		Size:         p.size,
+		Mtime:        p.mtime,
+		Sidecar:      p.sidecar,
+		SidecarMtime: p.sidecarMtime,
+		Reader:       reader,
+		Exif:         exif,
+	}
+}
+
+// valid checks that the cache entry matches the picture's files and the metadata reader.
+func (e *cacheEntry) valid(p *Pict, reader string) bool {
+	return e.Size == p.size && e.Mtime.Equal(p.mtime) && e.Sidecar == p.sidecar &&
+		e.SidecarMtime.Equal(p.sidecarMtime) && e.Reader == reader
+}
+
+// sidecarValid checks that the sidecar recorded in the entry is unchanged.
+func (e *cacheEntry) sidecarValid() bool {
+	if e.Sidecar == "" {
+		return true
+	}
+	mtime, err := getMtime(e.Sidecar)
+	return err == nil && mtime.Equal(e.SidecarMtime)
+}
+
+// Prune removes the entries for images (or sidecars) that no longer exist or have been modified,
+// returning the number of entries removed.
+func (c *ExifCache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for f, entries := range c.Entries {
+		st, err := os.Stat(f)
+		n := len(entries)
+		entries = slices.DeleteFunc(entries, func(e cacheEntry) bool {
+			return err != nil || st.Size() != e.Size || !st.ModTime().Equal(e.Mtime) || !e.sidecarValid()
+		})
+		removed += n - len(entries)
+		if len(entries) == 0 {
+			delete(c.Entries, f)
+		} else {
+			c.Entries[f] = entries
+		}
+	}
+	if removed > 0 {
+		c.changed = true
+	}
+	return removed
+}
+
+// Save writes the cache if it has been changed. The cache is written to a
+// temporary file and renamed so that a partially written cache is never read.
+func (c *ExifCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0755); err != nil {
+		return err
+	}
+	s, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("%s: marshal %w", c.file, err)
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, s, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.changed = false
+	return nil
+}
+
+// File returns the pathname of the cache file.
+func (c *ExifCache) File() string {
+	return c.file
+}
+
+// Len returns the number of entries in the cache.
+func (c *ExifCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, entries := range c.Entries {
+		n += len(entries)
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCacheReaders checks that galleries reading different metadata fields from the
+// same image keep separate cache entries, and that the entries survive a save and reload.
+func TestCacheReaders(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "a.jpg")
+	if err := os.WriteFile(f, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Pict{srcPath: f, size: st.Size(), mtime: st.ModTime()}
+	c := &ExifCache{file: filepath.Join(dir, cacheFile), Version: cacheVersion, Entries: make(map[string][]cacheEntry)}
+	c.add(p, "goexif/0/city", &Exif{title: "a", fields: map[string]string{"city": "Kota Kinabalu"}})
+	c.add(p, "goexif/0/keyword", &Exif{title: "a", fields: map[string]string{"keyword": "Birds"}})
+	c.add(p, "goexif/0/city", &Exif{title: "b", fields: map[string]string{"city": "Tawau"}})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	c = &ExifCache{file: c.file}
+	if err := readMeta(c.file, c); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("got %d entries, want 2", n)
+	}
+	tests := []struct {
+		reader, field, want string
+	}{
+		{"goexif/0/city", "city", "Tawau"},
+		{"goexif/0/keyword", "keyword", "Birds"},
+		{"goexif/0/city/keyword", "", ""},
+		{"exiv2/0/city", "", ""},
+	}
+	for _, tc := range tests {
+		e, ok := c.lookup(p, tc.reader)
+		if ok != (tc.field != "") {
+			t.Errorf("%s: got found %v, want %v", tc.reader, ok, tc.field != "")
+			continue
+		}
+		if ok && e.fields[tc.field] != tc.want {
+			t.Errorf("%s: got %s %q, want %q", tc.reader, tc.field, e.fields[tc.field], tc.want)
+		}
+	}
+	if err := os.WriteFile(f, []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Prune(); n != 2 || c.Len() != 0 {
+		t.Errorf("prune: removed %d entries, %d remaining, want 2 and 0", n, c.Len())
+	}
+}
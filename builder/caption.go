@@ -0,0 +1,142 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Caption modes, selecting where the titles of the photos are shown.
+const (
+	CAPTION_SHOW        = iota // Titles are shown on the thumbnails and image pages
+	CAPTION_HIDE               // No titles or captions are generated
+	CAPTION_HIDE_THUMBS        // Titles are only shown on the image pages
+)
+
+// Sources of the photo titles and captions.
+const (
+	SOURCE_CONFIG = iota // The caption keyword in the config file
+	SOURCE_IPTC          // IPTC object name, headline and caption
+	SOURCE_XMP           // XMP dc:title and dc:description
+	SOURCE_EXIF          // EXIF image description
+	SOURCE_TEXT          // Text file alongside the image
+)
+
+// Names of the caption sources used in the config file.
+var sourceNames = map[string]int{
+	"config": SOURCE_CONFIG,
+	"iptc":   SOURCE_IPTC,
+	"xmp":    SOURCE_XMP,
+	"exif":   SOURCE_EXIF,
+	"text":   SOURCE_TEXT,
+}
+
+// CaptionSource is a source of the photo titles and captions.
+type CaptionSource struct {
+	Source int    // SOURCE_CONFIG, SOURCE_IPTC, SOURCE_XMP, SOURCE_EXIF or SOURCE_TEXT
+	Lang   string // Language of the XMP language alternative, if set
+}
+
+// defaultSources are the caption sources used if none are configured.
+var defaultSources = []CaptionSource{{Source: SOURCE_CONFIG}, {Source: SOURCE_IPTC}}
+
+// parseCaptionSource parses a caption source of the form name or xmp:lang.
+func parseCaptionSource(s string) (CaptionSource, error) {
+	name, lang, hasLang := strings.Cut(s, ":")
+	src, ok := sourceNames[name]
+	if !ok {
+		return CaptionSource{}, fmt.Errorf("unknown caption source (%s)", s)
+	}
+	if hasLang && (src != SOURCE_XMP || lang == "") {
+		return CaptionSource{}, fmt.Errorf("language can only be selected for xmp (%s)", s)
+	}
+	return CaptionSource{Source: src, Lang: lang}, nil
+}
+
+// id returns the name used for the metadata fields of the source.
+func (cs CaptionSource) id() string {
+	switch cs.Source {
+	case SOURCE_XMP:
+		if cs.Lang != "" {
+			return "xmp:" + cs.Lang
+		}
+		return "xmp"
+	case SOURCE_EXIF:
+		return "exif"
+	}
+	return ""
+}
+
+// fields returns the metadata fields holding the title and caption of the source.
+// The IPTC title and caption are always read, and the config and text sources are not metadata.
+func (cs CaptionSource) fields() []metaField {
+	var title, caption []string
+	switch cs.Source {
+	case SOURCE_XMP:
+		title, caption = []string{"Xmp.dc.title"}, []string{"Xmp.dc.description"}
+		if cs.Lang != "" {
+			title = []string{"Xmp.dc.title[" + cs.Lang + "]"}
+			caption = []string{"Xmp.dc.description[" + cs.Lang + "]"}
+		}
+	case SOURCE_EXIF:
+		title = []string{"Exif.Image.ImageDescription"}
+		caption = title
+	default:
+		return nil
+	}
+	return []metaField{{"title:" + cs.id(), title}, {"caption:" + cs.id(), caption}}
+}
+
+// setCaptions sets the title and caption of each picture from the first source that
+// has a value. The config source uses the config file captions, followed by the
+// titles and captions in the manifest file.
+func (b *build) setCaptions(pl []*Pict) {
+	for _, p := range pl {
+		var title, caption string
+		for _, cs := range b.sources {
+			var t, c string
+			switch cs.Source {
+			case SOURCE_CONFIG:
+				if e, ok := b.photos[p.srcFile]; ok {
+					t, c = e.title, e.caption
+				}
+				if tmpl, ok := b.captions[p.srcFile]; ok {
+					t = tmpl.expand(p.photoValue)
+				}
+			case SOURCE_IPTC:
+				t, c = p.exif.title, p.exif.caption
+			case SOURCE_TEXT:
+				t, c = p.readText()
+			default:
+				t, c = p.exif.fields["title:"+cs.id()], p.exif.fields["caption:"+cs.id()]
+			}
+			if title == "" {
+				title = t
+			}
+			if caption == "" {
+				caption = c
+			}
+		}
+		if title != p.exif.title {
+			b.verbosef("%s: Setting title to <%s>\n", p.srcFile, title)
+		}
+		p.exif.title, p.exif.caption = title, caption
+	}
+}
+
+// readText reads the text file of the picture (<image>.txt or <image-without-extension>.txt),
+// returning the first line as the title, and the text as the caption.
+func (p *Pict) readText() (string, string) {
+	base := strings.TrimSuffix(p.srcPath, filepath.Ext(p.srcPath))
+	for _, f := range []string{p.srcPath + ".txt", base + ".txt", base + ".TXT"} {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		text := strings.TrimSpace(string(b))
+		title, _, _ := strings.Cut(text, "\n")
+		return strings.TrimSpace(title), text
+	}
+	return "", ""
+}
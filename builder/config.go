@@ -0,0 +1,808 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aamcrae/pweb/imager"
+	"github.com/thomasheller/braceexpansion"
+)
+
+type keyword = int
+
+const (
+	C_UP keyword = iota
+	C_TITLE
+	C_DIR
+	C_INCLUDE
+	C_EXCLUDE
+	C_STYLE
+	C_AFTER
+	C_BEFORE
+	C_RATING
+	C_SELECT
+	C_DOWNLOAD
+	C_NOCAPTION
+	C_SORT
+	C_REVERSE
+	C_LARGE
+	C_CAPTION
+	C_NOZIP
+	C_THUMB
+	C_SIDECAR
+	C_WIDTHS
+	C_FORMAT
+	C_ZIP
+	C_PAIR
+	C_NOCASE
+	C_IMPORT
+	C_CAPTION_FORMAT
+	C_CAPTION_SOURCE
+	C_MANIFEST
+	C_DATE_FROM
+	C_DATE_TO
+	C_CAMERA
+	C_LENS
+	C_KEYWORD
+	C_LABEL
+	C_ORIENTATION
+	C_FILTER
+)
+
+// DefaultsFile is the name of the file holding the default settings for the
+// galleries in the same directory and its subdirectories.
+const DefaultsFile = ".web-defaults"
+
+// configOptions contains some options for the configuration keywords.
+type configOptions struct {
+	code    keyword
+	min     int      // Minimum number of arguments
+	max     int      // Maximum number of arguments
+	multi   bool     // keyword can be used multiple times
+	str     bool     // Argument is a single string
+	allowed []string // If set, defines the allowed parameters
+}
+
+var configKeywords = map[string]*configOptions{
+	"up":             &configOptions{code: C_UP, min: 1, max: 1},
+	"title":          &configOptions{code: C_TITLE, min: 1, str: true},
+	"dir":            &configOptions{code: C_DIR, min: 1, max: 1},
+	"include":        &configOptions{code: C_INCLUDE, min: 1, multi: true},
+	"exclude":        &configOptions{code: C_EXCLUDE, min: 1, multi: true},
+	"style":          &configOptions{code: C_STYLE, min: 1, max: 1},
+	"after":          &configOptions{code: C_AFTER, min: 2, multi: true},
+	"before":         &configOptions{code: C_BEFORE, min: 2, multi: true},
+	"rating":         &configOptions{code: C_RATING, min: 1, max: 1, allowed: []string{"0", "1", "2", "3", "4", "5"}},
+	"select":         &configOptions{code: C_SELECT, min: 1, max: 6, allowed: []string{"0", "1", "2", "3", "4", "5"}},
+	"download":       &configOptions{code: C_DOWNLOAD, max: 1, allowed: []string{"", "static", "symlink", "off"}},
+	"nocaption":      &configOptions{code: C_NOCAPTION, max: 1, allowed: []string{"", "thumbs", "off"}},
+	"sort":           &configOptions{code: C_SORT, min: 1, str: true},
+	"reverse":        &configOptions{code: C_REVERSE, max: 1, allowed: []string{"", "off"}},
+	"large":          &configOptions{code: C_LARGE, max: 1, allowed: []string{"", "off"}},
+	"caption":        &configOptions{code: C_CAPTION, min: 2, str: true, multi: true},
+	"nozip":          &configOptions{code: C_NOZIP, max: 1, allowed: []string{"", "off"}},
+	"thumb":          &configOptions{code: C_THUMB, min: 1, max: 1},
+	"sidecar":        &configOptions{code: C_SIDECAR, min: 1, max: 1, allowed: []string{"prefer", "ignore", "only"}},
+	"widths":         &configOptions{code: C_WIDTHS, max: 10},
+	"format":         &configOptions{code: C_FORMAT, min: 1, max: 3, allowed: []string{"jpeg", "webp", "avif"}},
+	"zip":            &configOptions{code: C_ZIP, min: 1, max: 1, allowed: []string{"store", "deflate"}},
+	"pair":           &configOptions{code: C_PAIR, max: 1, allowed: []string{"", "zip", "off"}},
+	"nocase":         &configOptions{code: C_NOCASE, max: 1, allowed: []string{"", "off"}},
+	"import":         &configOptions{code: C_IMPORT, min: 1, multi: true},
+	"caption-format": &configOptions{code: C_CAPTION_FORMAT, min: 1, str: true},
+	"caption-source": &configOptions{code: C_CAPTION_SOURCE, min: 1, max: 5},
+	"manifest":       &configOptions{code: C_MANIFEST, min: 1, str: true},
+	"date-from":      &configOptions{code: C_DATE_FROM, min: 1, str: true},
+	"date-to":        &configOptions{code: C_DATE_TO, min: 1, str: true},
+	"camera":         &configOptions{code: C_CAMERA, min: 1, max: 10},
+	"lens":           &configOptions{code: C_LENS, min: 1, max: 10},
+	"keyword":        &configOptions{code: C_KEYWORD, min: 1, multi: true},
+	"label":          &configOptions{code: C_LABEL, min: 1, max: 10},
+	"orientation":    &configOptions{code: C_ORIENTATION, min: 1, max: 1, allowed: []string{"portrait", "landscape", "square"}},
+	"filter":         &configOptions{code: C_FILTER, min: 1, str: true, multi: true},
+}
+
+// Keywords that are alternative forms of the same setting, so that setting
+// one of them in a file replaces the others.
+var configAlternatives = map[keyword][]keyword{
+	C_RATING: {C_SELECT},
+	C_SELECT: {C_RATING},
+}
+
+// ConfigEntry holds the arguments of one use of a keyword in the config file.
+type ConfigEntry struct {
+	File string   // Config file containing the keyword
+	Line int      // Line number in the config file
+	Args []string // Arguments, with any quotes and escapes removed
+}
+
+// arg returns the argument at the index, or an empty string if there is no such argument.
+func (e ConfigEntry) arg(i int) string {
+	if i < len(e.Args) {
+		return e.Args[i]
+	}
+	return ""
+}
+
+// configError returns an error for the keyword at the config entry.
+func (e ConfigEntry) configError(kw string, err error) *ConfigError {
+	return &ConfigError{File: e.File, Line: e.Line, Keyword: kw, Err: err}
+}
+
+// Config holds the arguments of the config file keywords.
+// Keywords that are used multiple times have an entry for each use.
+type Config map[int][]ConfigEntry
+
+// ReadConfig reads the config file, along with any defaults files and imported files.
+// The map value is the parameters for the keyword, with an entry for each use of
+// the keyword. The defaults files (DefaultsFile) in the directory of the config file and
+// its parent directories are read first, starting with the top level directory, and
+// each file's settings replace those of the files read before it (see Config.read).
+func ReadConfig(f string) (Config, error) {
+	conf := make(Config)
+	abs, err := filepath.Abs(f)
+	if err != nil {
+		return conf, err
+	}
+	var defaults []string
+	for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+		df := filepath.Join(d, DefaultsFile)
+		if df != abs {
+			if _, err := os.Stat(df); err == nil {
+				defaults = append(defaults, df)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return conf, err
+			}
+		}
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	slices.Reverse(defaults)
+	for _, df := range defaults {
+		if err := conf.read(df, nil); err != nil {
+			return conf, err
+		}
+	}
+	return conf, conf.read(f, nil)
+}
+
+// read reads a config file, and merges the settings into the config. Files imported via
+// the import keyword are read first (relative to the directory of the importing file), so the
+// settings in the file itself take precedence. A keyword in the file replaces all the
+// entries of the keyword (and of its alternatives) already in the config, except for captions,
+// which are added.
+// importers is the list of files importing this file, used to detect import loops.
+func (conf Config) read(f string, importers []string) error {
+	local, err := readConfigFile(f)
+	if err != nil {
+		return err
+	}
+	importers = append(slices.Clone(importers), filepath.Clean(f))
+	for _, imp := range local[C_IMPORT] {
+		for _, file := range imp.Args {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(f), file)
+			}
+			if slices.Contains(importers, filepath.Clean(file)) {
+				return imp.configError("import", fmt.Errorf("import loop (%s)", file))
+			}
+			if err := conf.read(file, importers); err != nil {
+				if _, ok := err.(*ConfigError); !ok {
+					err = imp.configError("import", err)
+				}
+				return err
+			}
+		}
+	}
+	delete(local, C_IMPORT)
+	for code, entries := range local {
+		if code == C_CAPTION {
+			conf[code] = append(conf[code], entries...)
+		} else {
+			for _, alt := range configAlternatives[code] {
+				if _, ok := local[alt]; !ok {
+					delete(conf, alt)
+				}
+			}
+			conf[code] = entries
+		}
+	}
+	return nil
+}
+
+// flag returns true if the keyword is set, and is not turned off with "off".
+func (conf Config) flag(code keyword) bool {
+	e, ok := conf[code]
+	return ok && e[0].arg(0) != "off"
+}
+
+// readConfigFile parses a single config file and stores the parameters into
+// a map. Some keywords may have multiple entries - these are added to the
+// entries for the keyword.
+// Lines ending with a '\' are continued on the next line. Arguments are separated by white space,
+// and may be quoted (see splitArgs). A '#' at the start of a line starts a comment, as does
+// a '#' at the start of an argument, except for keywords taking free text (e.g title and caption),
+// which are read by strArgs and are not continued.
+func readConfigFile(f string) (Config, error) {
+	conf := make(Config)
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return conf, err
+	}
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		l := strings.TrimSpace(lines[i])
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+		kw, arg, ok := strings.Cut(l, ":")
+		if !ok {
+			return conf, &ConfigError{File: f, Line: lineNo, Err: errors.New("illegal config")}
+		}
+		kw = strings.TrimSpace(kw)
+		c, ok := configKeywords[kw]
+		if !ok {
+			return conf, &ConfigError{File: f, Line: lineNo, Err: fmt.Errorf("unknown keyword (%s)", kw)}
+		}
+		// Join any continuation lines. Free text is not continued, so that it may end with a '\'.
+		for !c.str && continued(arg) && i+1 < len(lines) {
+			i++
+			arg = arg[:len(arg)-1] + " " + strings.TrimRight(lines[i], " \t\r")
+		}
+		if !c.str && continued(arg) {
+			return conf, &ConfigError{File: f, Line: lineNo, Keyword: kw, Err: errors.New("continuation at end of file")}
+		}
+		if !c.multi && len(conf[c.code]) > 0 {
+			return conf, &ConfigError{File: f, Line: lineNo, Keyword: kw, Err: errors.New("duplicate keyword")}
+		}
+		var args []string
+		if c.str {
+			args, err = strArgs(arg, c.min)
+		} else {
+			args, err = splitArgs(arg)
+		}
+		if err != nil {
+			return conf, &ConfigError{File: f, Line: lineNo, Keyword: kw, Err: err}
+		}
+		if len(args) < c.min {
+			return conf, &ConfigError{File: f, Line: lineNo, Keyword: kw, Err: errors.New("not enough arguments")}
+		}
+		if !c.str && !c.multi && len(args) > c.max {
+			return conf, &ConfigError{File: f, Line: lineNo, Keyword: kw, Err: errors.New("too many arguments")}
+		}
+		for _, a := range args {
+			if len(c.allowed) > 0 && !slices.Contains(c.allowed, a) {
+				return conf, &ConfigError{File: f, Line: lineNo, Keyword: kw, Err: fmt.Errorf("illegal argument '%s'", a)}
+			}
+		}
+		e := ConfigEntry{File: f, Line: lineNo, Args: args}
+		conf[c.code] = append(conf[c.code], e)
+	}
+	return conf, nil
+}
+
+// continued returns true if the line ends with an unescaped '\'.
+func continued(l string) bool {
+	n := len(l) - len(strings.TrimRight(l, "\\"))
+	return n%2 == 1
+}
+
+// splitArgs splits the arguments of a config line. Arguments are separated by white space.
+// An argument starting with a double quote extends to the closing double quote, and
+// a backslash escapes the next character. An argument starting with a single quote extends
+// to the closing single quote, with no escapes. Outside of quotes, a backslash escapes the next
+// character (e.g "\ " or "\#"). An unescaped '#' at the start of an argument starts a comment.
+// Environment variables of the form ${NAME} are replaced by their values, except in single quotes.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	for i := skipSpace(s, 0); i < len(s) && s[i] != '#'; i = skipSpace(s, i) {
+		a, end, err := nextArg(s, i, true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		i = end
+	}
+	return args, nil
+}
+
+// skipSpace returns the position of the first character at or after i that is not white space.
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// nextArg returns the text of the argument starting at the position (see splitArgs), and the position
+// following it. If strict is not set, environment variable references that cannot be replaced are
+// left as written, rather than being an error.
+func nextArg(s string, i int, strict bool) (string, int, error) {
+	var text strings.Builder
+	if q := s[i]; q == '"' || q == '\'' {
+		i++
+		for {
+			if i >= len(s) {
+				return "", 0, errors.New("missing closing quote")
+			}
+			if s[i] == q {
+				i++
+				break
+			}
+			if q == '"' && isEnvVar(s, i) {
+				v, next, err := envVar(s, i, strict)
+				if err != nil {
+					return "", 0, err
+				}
+				text.WriteString(v)
+				i = next
+				continue
+			}
+			if q == '"' && s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			text.WriteByte(s[i])
+			i++
+		}
+		if i < len(s) && s[i] != ' ' && s[i] != '\t' {
+			return "", 0, errors.New("text after closing quote")
+		}
+		return text.String(), i, nil
+	}
+	for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+		if isEnvVar(s, i) {
+			v, next, err := envVar(s, i, strict)
+			if err != nil {
+				return "", 0, err
+			}
+			text.WriteString(v)
+			i = next
+			continue
+		}
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		text.WriteByte(s[i])
+		i++
+	}
+	return text.String(), i, nil
+}
+
+// isEnvVar returns true if there is an environment variable reference at the position.
+func isEnvVar(s string, i int) bool {
+	return strings.HasPrefix(s[i:], "${")
+}
+
+// envVar returns the value of the environment variable referenced at the position,
+// and the position following the reference. If strict is not set, a reference that is
+// unterminated, is not a valid name or refers to a variable that is not set is returned as is.
+func envVar(s string, i int, strict bool) (string, int, error) {
+	end := strings.IndexByte(s[i:], '}')
+	if end < 0 {
+		if !strict {
+			return s[i : i+2], i + 2, nil
+		}
+		return "", 0, errors.New("missing '}' in environment variable")
+	}
+	name := s[i+2 : i+end]
+	v, ok := os.LookupEnv(name)
+	if !strict && (!ok || !isName(name)) {
+		return s[i : i+end+1], i + end + 1, nil
+	}
+	if !ok {
+		return "", 0, fmt.Errorf("environment variable %s not set", name)
+	}
+	return v, i + end + 1, nil
+}
+
+// isName returns true if the string is a valid environment variable name.
+func isName(s string) bool {
+	for i, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && (i == 0 || !(c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// strArgs returns the arguments of a keyword whose last argument is free text (e.g a title or caption).
+// The leading n-1 arguments are read as for other keywords (see splitArgs), and the rest of the line is the text.
+// If the text is wholly enclosed in quotes, it is read as a single quoted argument. Otherwise quotes and
+// backslashes are used as written, apart from "\#", which is replaced by '#'. Environment variables
+// are replaced, except in single quotes, and references to variables that are not set are left as written.
+func strArgs(s string, n int) ([]string, error) {
+	var args []string
+	i := skipSpace(s, 0)
+	for ; len(args) < n-1 && i < len(s); i = skipSpace(s, i) {
+		a, end, err := nextArg(s, i, true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		i = end
+	}
+	text := strings.TrimRight(s[i:], " \t\r")
+	if text == "" {
+		return args, nil
+	}
+	if q := text[0]; (q == '"' || q == '\'') && len(text) > 1 && text[len(text)-1] == q {
+		if a, end, err := nextArg(text, 0, false); err == nil && end == len(text) {
+			return append(args, a), nil
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		if isEnvVar(text, i) {
+			v, next, _ := envVar(text, i, false)
+			b.WriteString(v)
+			i = next
+		} else if strings.HasPrefix(text[i:], "\\#") {
+			b.WriteByte('#')
+			i += 2
+		} else {
+			b.WriteByte(text[i])
+			i++
+		}
+	}
+	return append(args, b.String()), nil
+}
+
+// Write writes the config in the config file syntax, ordered by keyword, with a comment
+// on each line giving the file and line number where the setting was read.
+func (conf Config) Write(w io.Writer) error {
+	names := make(map[keyword]string)
+	for name, c := range configKeywords {
+		names[c.code] = name
+	}
+	var codes []keyword
+	for code := range conf {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, code := range codes {
+		for _, e := range conf[code] {
+			line := names[code] + ":"
+			for _, a := range e.Args {
+				line += " " + quoteArg(a)
+			}
+			fmt.Fprintf(tw, "%s\t# %s:%d\n", line, e.File, e.Line)
+		}
+	}
+	return tw.Flush()
+}
+
+// quoteArg quotes the argument if necessary, so that it is read back unchanged.
+func quoteArg(a string) string {
+	if a == "" || a[0] == '#' || strings.ContainsAny(a, " \t\"'\\") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(a) + `"`
+	}
+	return a
+}
+
+// GalleryConfig is the configuration of a single gallery.
+type GalleryConfig struct {
+	SrcDir         string            // Directory containing the photos, if not the current directory
+	Dir            string            // Gallery directory, relative to the base directory
+	Title          string            // Gallery title
+	Up             string            // Link to the referring album, if any
+	Reverse        bool              // Add the gallery to the end of the referring album
+	Style          string            // Gallery style
+	Include        []string          // Wildcards of the files to be included
+	NoCase         bool              // Match the wildcards without regard to case
+	Exclude        []string          // Wildcards of the files to be excluded
+	After          [][]string        // Anchor file followed by the files to be inserted after it
+	Before         [][]string        // Anchor file followed by the files to be inserted before it
+	Rating         string            // If set, minimum rating of the photos selected
+	Select         []string          // If set, the ratings of the photos selected
+	DateFrom       time.Time         // If set, photos taken before this time are excluded
+	DateTo         time.Time         // If set, photos taken at or after this time are excluded
+	Camera         []string          // If set, wildcards of the camera models of the photos selected
+	Lens           []string          // If set, wildcards of the lenses of the photos selected
+	Keywords       [][]string        // Lists of keywords; photos must have a keyword from each list
+	Label          []string          // If set, wildcards of the colour labels of the photos selected
+	Orientation    int               // Orientation of the photos selected (ORIENT_ANY, ORIENT_PORTRAIT, ORIENT_LANDSCAPE or ORIENT_SQUARE)
+	Filters        []string          // Filter expressions that photos must match
+	Download       int               // Download mode (DL_NONE, DL_SYMLINK or DL_STATIC)
+	NoZip          bool              // Do not generate a zip file of the downloads
+	ZipStore       bool              // Add compressed files to the zip file without compression
+	Sort           []SortKey         // Sort keys, in order of precedence
+	Large          bool              // Generate larger images
+	Captions       map[string]string // Titles of the photos, keyed by filename
+	CaptionFormat  string            // Template of the titles of photos without a title
+	NoCaption      int               // Caption mode (CAPTION_SHOW, CAPTION_HIDE or CAPTION_HIDE_THUMBS)
+	CaptionSources []CaptionSource   // Sources of the titles and captions, in order of precedence
+	Manifest       string            // Manifest file of titles, captions, ratings and ordering, if any
+	Thumb          int               // Thumbnail width and height
+	Sidecar        int               // Sidecar mode (SIDECAR_PREFER, SIDECAR_IGNORE or SIDECAR_ONLY)
+	Widths         []int             // Widths of the additional scaled images
+	Formats        []imager.Format   // Additional image formats
+	Pair           int               // Raw file pairing (PAIR_NONE, PAIR_DOWNLOAD or PAIR_ZIP)
+}
+
+// NewGalleryConfig returns a gallery configuration with the default settings.
+func NewGalleryConfig() *GalleryConfig {
+	return &GalleryConfig{
+		Title:          "Photo album",
+		Include:        []string{"*.jpg", "*.jpeg"},
+		NoCase:         true,
+		ZipStore:       true,
+		Captions:       make(map[string]string),
+		CaptionSources: slices.Clone(defaultSources),
+		Thumb:          160,
+		Widths:         []int{640, 1024},
+	}
+}
+
+// LoadConfig reads and parses the config file.
+func LoadConfig(f string) (*GalleryConfig, error) {
+	conf, err := ReadConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	gc, err := ParseConfig(conf)
+	if ce, ok := err.(*ConfigError); ok && ce.File == "" {
+		ce.File = f
+	}
+	return gc, err
+}
+
+// ParseConfig converts the config file arguments into a gallery configuration.
+// Settings not present in the config are set to the defaults.
+func ParseConfig(conf Config) (*GalleryConfig, error) {
+	gc := NewGalleryConfig()
+	d, ok := conf[C_DIR]
+	if !ok {
+		return nil, &ConfigError{Keyword: "dir", Err: errors.New("missing keyword")}
+	}
+	gc.Dir = d[0].arg(0)
+	if t, ok := conf[C_TITLE]; ok {
+		gc.Title = t[0].arg(0)
+		if _, err := parseTemplate(gc.Title, true); err != nil {
+			return nil, t[0].configError("title", err)
+		}
+	}
+	if up, ok := conf[C_UP]; ok {
+		gc.Up = up[0].arg(0)
+	}
+	gc.Reverse = conf.flag(C_REVERSE)
+	if st, ok := conf[C_STYLE]; ok {
+		gc.Style = st[0].arg(0)
+	}
+	if sc, ok := conf[C_SIDECAR]; ok {
+		switch sc[0].arg(0) {
+		case "prefer":
+			gc.Sidecar = SIDECAR_PREFER
+		case "ignore":
+			gc.Sidecar = SIDECAR_IGNORE
+		case "only":
+			gc.Sidecar = SIDECAR_ONLY
+		}
+	}
+	// The default include list matches without regard to case, otherwise
+	// matching is case sensitive unless nocase is set.
+	if incList, ok := conf[C_INCLUDE]; ok {
+		gc.Include = nil
+		for _, e := range incList {
+			if err := checkPatterns(e.Args); err != nil {
+				return nil, e.configError("include", err)
+			}
+			gc.Include = append(gc.Include, e.Args...)
+		}
+		gc.NoCase = conf.flag(C_NOCASE)
+	}
+	for _, e := range conf[C_EXCLUDE] {
+		if err := checkPatterns(e.Args); err != nil {
+			return nil, e.configError("exclude", err)
+		}
+		gc.Exclude = append(gc.Exclude, e.Args...)
+	}
+	for _, e := range conf[C_AFTER] {
+		if err := checkPatterns(e.Args[1:]); err != nil {
+			return nil, e.configError("after", err)
+		}
+		gc.After = append(gc.After, e.Args)
+	}
+	for _, e := range conf[C_BEFORE] {
+		if err := checkPatterns(e.Args[1:]); err != nil {
+			return nil, e.configError("before", err)
+		}
+		gc.Before = append(gc.Before, e.Args)
+	}
+	ratings, useRating := conf[C_RATING]
+	sel, useSelect := conf[C_SELECT]
+	if useRating && useSelect {
+		return nil, sel[0].configError("select", errors.New("cannot use both select and rating"))
+	}
+	if useRating {
+		gc.Rating = ratings[0].arg(0)
+	}
+	if useSelect {
+		gc.Select = sel[0].Args
+	}
+	// If a thumbnail size is set, use it.
+	if thsz, ok := conf[C_THUMB]; ok {
+		if _, err := fmt.Sscanf(thsz[0].arg(0), "%d", &gc.Thumb); err != nil || gc.Thumb <= 0 {
+			return nil, thsz[0].configError("thumb", fmt.Errorf("bad thumbnail size (%s)", thsz[0].arg(0)))
+		}
+	}
+	// If image widths are set, use them (an empty list disables the extra images).
+	if wl, ok := conf[C_WIDTHS]; ok {
+		gc.Widths = nil
+		for _, ws := range wl[0].Args {
+			var w int
+			if _, err := fmt.Sscanf(ws, "%d", &w); err != nil || w <= 0 {
+				return nil, wl[0].configError("widths", fmt.Errorf("bad image width (%s)", ws))
+			}
+			gc.Widths = append(gc.Widths, w)
+		}
+	}
+	if fl, ok := conf[C_FORMAT]; ok {
+		for _, fs := range fl[0].Args {
+			f, ok := imager.ParseFormat(fs)
+			if !ok {
+				return nil, fl[0].configError("format", fmt.Errorf("unknown format (%s)", fs))
+			}
+			if f != imager.JPEG && !slices.Contains(gc.Formats, f) {
+				gc.Formats = append(gc.Formats, f)
+			}
+		}
+	}
+	// Build map of captions
+	if cl, ok := conf[C_CAPTION]; ok {
+		for _, c := range cl {
+			if _, err := parseTemplate(c.arg(1), false); err != nil {
+				return nil, c.configError("caption", err)
+			}
+		}
+		buildCaptions(cl, gc.Captions)
+	}
+	if cf, ok := conf[C_CAPTION_FORMAT]; ok {
+		gc.CaptionFormat = cf[0].arg(0)
+		if _, err := parseTemplate(gc.CaptionFormat, false); err != nil {
+			return nil, cf[0].configError("caption-format", err)
+		}
+	}
+	if nc, ok := conf[C_NOCAPTION]; ok {
+		switch nc[0].arg(0) {
+		case "":
+			gc.NoCaption = CAPTION_HIDE
+		case "thumbs":
+			gc.NoCaption = CAPTION_HIDE_THUMBS
+		}
+	}
+	if cs, ok := conf[C_CAPTION_SOURCE]; ok {
+		gc.CaptionSources = nil
+		for _, a := range cs[0].Args {
+			src, err := parseCaptionSource(a)
+			if err != nil {
+				return nil, cs[0].configError("caption-source", err)
+			}
+			gc.CaptionSources = append(gc.CaptionSources, src)
+		}
+	}
+	if m, ok := conf[C_MANIFEST]; ok {
+		gc.Manifest = m[0].arg(0)
+	}
+	if err := parseFilters(conf, gc); err != nil {
+		return nil, err
+	}
+	// If configured, sort using the sort keys. Otherwise leave pictures in the include order.
+	if skey, ok := conf[C_SORT]; ok {
+		var err error
+		if gc.Sort, err = parseSort(skey[0].arg(0)); err != nil {
+			return nil, skey[0].configError("sort", err)
+		}
+	}
+	gc.Large = conf.flag(C_LARGE)
+	if dl_arg, ok := conf[C_DOWNLOAD]; ok {
+		switch dl_arg[0].arg(0) {
+		case "", "symlink":
+			gc.Download = DL_SYMLINK
+		case "static":
+			gc.Download = DL_STATIC
+		}
+	}
+	gc.NoZip = conf.flag(C_NOZIP)
+	if z, ok := conf[C_ZIP]; ok && z[0].arg(0) == "deflate" {
+		gc.ZipStore = false
+	}
+	if pr, ok := conf[C_PAIR]; ok {
+		switch pr[0].arg(0) {
+		case "":
+			gc.Pair = PAIR_DOWNLOAD
+		case "zip":
+			gc.Pair = PAIR_ZIP
+		}
+	}
+	return gc, nil
+}
+
+// checkPatterns checks that the wildcards are valid, so that errors can be reported
+// with the line of the config file.
+func checkPatterns(patterns []string) error {
+	for _, p := range patterns {
+		tree, err := braceexpansion.New().Parse(p)
+		if err != nil {
+			return err
+		}
+		for _, exp := range tree.Expand() {
+			if _, err := filepath.Match(exp, ""); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseFilters parses the keywords used to select the photos.
+func parseFilters(conf Config, gc *GalleryConfig) error {
+	var err error
+	if d, ok := conf[C_DATE_FROM]; ok {
+		if gc.DateFrom, _, err = parseDate(d[0].arg(0)); err != nil {
+			return d[0].configError("date-from", err)
+		}
+	}
+	if d, ok := conf[C_DATE_TO]; ok {
+		// The end date is inclusive, so that e.g "2024-03" includes all of March.
+		if _, gc.DateTo, err = parseDate(d[0].arg(0)); err != nil {
+			return d[0].configError("date-to", err)
+		}
+	}
+	if !gc.DateFrom.IsZero() && !gc.DateTo.IsZero() && !gc.DateFrom.Before(gc.DateTo) {
+		return conf[C_DATE_TO][0].configError("date-to", errors.New("date-to is before date-from"))
+	}
+	wildcards := func(code keyword, kw string) ([]string, error) {
+		ce, ok := conf[code]
+		if !ok {
+			return nil, nil
+		}
+		if err := checkWildcards(ce[0].Args); err != nil {
+			return nil, ce[0].configError(kw, err)
+		}
+		return ce[0].Args, nil
+	}
+	if gc.Camera, err = wildcards(C_CAMERA, "camera"); err != nil {
+		return err
+	}
+	if gc.Lens, err = wildcards(C_LENS, "lens"); err != nil {
+		return err
+	}
+	if gc.Label, err = wildcards(C_LABEL, "label"); err != nil {
+		return err
+	}
+	for _, ce := range conf[C_KEYWORD] {
+		if err := checkWildcards(ce.Args); err != nil {
+			return ce.configError("keyword", err)
+		}
+		gc.Keywords = append(gc.Keywords, ce.Args)
+	}
+	if o, ok := conf[C_ORIENTATION]; ok {
+		gc.Orientation = orientNames[o[0].arg(0)]
+	}
+	for _, ce := range conf[C_FILTER] {
+		if _, err := parseFilter(ce.arg(0)); err != nil {
+			return ce.configError("filter", err)
+		}
+		gc.Filters = append(gc.Filters, ce.arg(0))
+	}
+	return nil
+}
+
+// buildCaptions will build a map of image filenames to
+// any captions that are defined in the config file.
+func buildCaptions(cl []ConfigEntry, capt map[string]string) {
+	for _, c := range cl {
+		// Caption is of the form <img_file Caption to be added>
+		capt[c.arg(0)] = c.arg(1)
+	}
+}
@@ -0,0 +1,241 @@
+package builder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the config text to a file in a temporary directory, returning the file name.
+func writeConfig(t *testing.T, text string) string {
+	f := filepath.Join(t.TempDir(), "web")
+	if err := os.WriteFile(f, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSplitArgs(t *testing.T) {
+	t.Setenv("PWEB_TEST", "a b")
+	tests := []struct {
+		in   string
+		want []string
+		err  string
+	}{
+		{in: ""},
+		{in: "  a  b\tc ", want: []string{"a", "b", "c"}},
+		{in: `"a b" 'c d'`, want: []string{"a b", "c d"}},
+		{in: `"say \"cheese\".jpg"`, want: []string{`say "cheese".jpg`}},
+		{in: `'a\b'`, want: []string{`a\b`}},
+		{in: `my\ photo.jpg a\\b`, want: []string{"my photo.jpg", `a\b`}},
+		{in: "a # comment", want: []string{"a"}},
+		{in: "# comment"},
+		{in: "a#b", want: []string{"a#b"}},
+		{in: `\#a "#b" '#c'`, want: []string{"#a", "#b", "#c"}},
+		{in: "${PWEB_TEST}/x", want: []string{"a b/x"}},
+		{in: `"${PWEB_TEST}" '${PWEB_TEST}'`, want: []string{"a b", "${PWEB_TEST}"}},
+		{in: `"a b`, err: "missing closing quote"},
+		{in: `'a'b`, err: "text after closing quote"},
+		{in: "${PWEB_TEST", err: "missing '}'"},
+		{in: "${PWEB_TEST_UNSET}", err: "PWEB_TEST_UNSET not set"},
+	}
+	for _, tc := range tests {
+		got, err := splitArgs(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("splitArgs(%q): got error %v, want %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitArgs(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitArgs(%q): got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestReadConfigFile checks the arguments read for each keyword.
+func TestReadConfigFile(t *testing.T) {
+	t.Setenv("PWEB_TEST", "Kinabalu")
+	tests := []struct {
+		kw   string
+		text string
+		want [][]string // Arguments of each entry
+	}{
+		{"up", "up: ../index.html # comment", [][]string{{"../index.html"}}},
+		{"title", "title: Day 2 & 3: Climbing ${PWEB_TEST}", [][]string{{"Day 2 & 3: Climbing Kinabalu"}}},
+		{"title", "title: Shot #3 of 'many'", [][]string{{"Shot #3 of 'many'"}}},
+		{"title", `title: Shot \#3`, [][]string{{"Shot #3"}}},
+		{"title", "title: '${PWEB_TEST} #1'", [][]string{{"${PWEB_TEST} #1"}}},
+		{"title", `title: "Climbing \"${PWEB_TEST}\""`, [][]string{{`Climbing "Kinabalu"`}}},
+		{"title", "title: 'Twas the night", [][]string{{"'Twas the night"}}},
+		{"title", `title: "${PWEB_TEST}" trip`, [][]string{{`"Kinabalu" trip`}}},
+		{"title", `title: "Kinabalu" and 'Tawau'`, [][]string{{`"Kinabalu" and 'Tawau'`}}},
+		{"title", "title: cost ${5} or $5, ${PWEB_TEST_UNSET} ${PWEB_TEST", [][]string{{"cost ${5} or $5, ${PWEB_TEST_UNSET} ${PWEB_TEST"}}},
+		{"title", "title: Up\\Down\\", [][]string{{`Up\Down\`}}},
+		{"dir", "  dir: hiking/${PWEB_TEST}", [][]string{{"hiking/Kinabalu"}}},
+		{"include", "include: day-2/*.jpg \\\n    \"day-3/Summit photo*.jpg\"   # Quoted\ninclude: x.jpg",
+			[][]string{{"day-2/*.jpg", "day-3/Summit photo*.jpg"}, {"x.jpg"}}},
+		{"exclude", `exclude: */img_234[5-7].jpg a\#b.jpg`, [][]string{{"*/img_234[5-7].jpg", "a#b.jpg"}}},
+		{"style", "style: dark", [][]string{{"dark"}}},
+		{"after", "after: img_1234.jpg other/*.jpg", [][]string{{"img_1234.jpg", "other/*.jpg"}}},
+		{"before", "before: img_4321.jpg a.jpg b.jpg", [][]string{{"img_4321.jpg", "a.jpg", "b.jpg"}}},
+		{"rating", "rating: 3", [][]string{{"3"}}},
+		{"select", "select: 2 4 5", [][]string{{"2", "4", "5"}}},
+		{"download", "download:", [][]string{nil}},
+		{"download", "download: static", [][]string{{"static"}}},
+		{"nocaption", "nocaption: thumbs", [][]string{{"thumbs"}}},
+		{"sort", "sort: rating desc, date", [][]string{{"rating desc, date"}}},
+		{"reverse", "reverse:", [][]string{nil}},
+		{"large", "large:   # comment", [][]string{nil}},
+		{"large", "large: off", [][]string{{"off"}}},
+		{"caption", "caption: img1234.jpg Nice #1 flowers", [][]string{{"img1234.jpg", "Nice #1 flowers"}}},
+		{"caption", "caption: \"a b.jpg\" Nice\ncaption: c.jpg 'Other'", [][]string{{"a b.jpg", "Nice"}, {"c.jpg", "Other"}}},
+		{"caption", "caption: a.jpg Ends with \\\ncaption: b.jpg 'Tis \"Kinabalu\"", [][]string{{"a.jpg", `Ends with \`}, {"b.jpg", `'Tis "Kinabalu"`}}},
+		{"nozip", "nozip:", [][]string{nil}},
+		{"thumb", "thumb: 200", [][]string{{"200"}}},
+		{"sidecar", "sidecar: only", [][]string{{"only"}}},
+		{"widths", "widths: 480 800 \\\n 1200", [][]string{{"480", "800", "1200"}}},
+		{"widths", "widths:", [][]string{nil}},
+		{"format", "format: webp avif", [][]string{{"webp", "avif"}}},
+		{"zip", "zip: deflate", [][]string{{"deflate"}}},
+		{"pair", "pair: zip", [][]string{{"zip"}}},
+		{"nocase", "nocase:", [][]string{nil}},
+		{"import", "import: ../common/style other", [][]string{{"../common/style", "other"}}},
+		{"caption-format", "caption-format: {location}, {date} #{n}", [][]string{{"{location}, {date} #{n}"}}},
+		{"caption-source", "caption-source: xmp:de config iptc", [][]string{{"xmp:de", "config", "iptc"}}},
+		{"manifest", "manifest: photos #1.csv", [][]string{{"photos #1.csv"}}},
+		{"date-from", "date-from: 2024-03-01 14:30", [][]string{{"2024-03-01 14:30"}}},
+		{"date-to", "date-to: 2024-03", [][]string{{"2024-03"}}},
+		{"camera", `camera: X-T5 "*EOS R*"`, [][]string{{"X-T5", "*EOS R*"}}},
+		{"lens", `lens: "XF 16-55*"`, [][]string{{"XF 16-55*"}}},
+		{"keyword", "keyword: birds \"bird*\"\nkeyword: sabah", [][]string{{"birds", "bird*"}, {"sabah"}}},
+		{"label", "label: Red Green", [][]string{{"Red", "Green"}}},
+		{"orientation", "orientation: portrait", [][]string{{"portrait"}}},
+		{"filter", `filter: rating>=3 && model=="X-T5"`, [][]string{{`rating>=3 && model=="X-T5"`}}},
+	}
+	tested := make(map[string]bool)
+	for _, tc := range tests {
+		tested[tc.kw] = true
+		f := writeConfig(t, "# comment\n\n    # indented comment\n"+tc.text+"\n")
+		conf, err := readConfigFile(f)
+		if err != nil {
+			t.Errorf("%q: %v", tc.text, err)
+			continue
+		}
+		entries := conf[configKeywords[tc.kw].code]
+		var got [][]string
+		for _, e := range entries {
+			got = append(got, e.Args)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.text, got, tc.want)
+		}
+		if len(entries) > 0 && entries[0].Line != 4 {
+			t.Errorf("%q: got line %d, want 4", tc.text, entries[0].Line)
+		}
+	}
+	for kw := range configKeywords {
+		if !tested[kw] {
+			t.Errorf("keyword %s not tested", kw)
+		}
+	}
+	// Free text ending with a '\' on the last line of the file is not a continuation.
+	conf, err := readConfigFile(writeConfig(t, `title: a\`))
+	if err != nil {
+		t.Errorf("title ending in '\\': %v", err)
+	} else if got := conf[C_TITLE][0].Args; !slices.Equal(got, []string{`a\`}) {
+		t.Errorf("title ending in '\\': got %q, want %q", got, `a\`)
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		line int
+		err  string
+	}{
+		{"dir: a\n\nfoo: b", 3, "unknown keyword (foo)"},
+		{"dir a", 1, "illegal config"},
+		{"title: a\ntitle: b", 2, "duplicate keyword"},
+		{"# comment\ndir:", 2, "not enough arguments"},
+		{"dir: a b", 1, "too many arguments"},
+		{"\nrating: 6", 2, "illegal argument '6'"},
+		{"include: a \\\n b \\\n c\ndir: \"a", 4, "missing closing quote"},
+		{"dir: a\ninclude: a \\", 2, "continuation at end of file"},
+		{"title: \\\ndir: a\ndir: b", 3, "duplicate keyword"},
+		{"dir: ${PWEB_TEST_UNSET}", 1, "PWEB_TEST_UNSET not set"},
+		{"caption: a.jpg", 1, "not enough arguments"},
+		{"caption: \"a.jpg Nice", 1, "missing closing quote"},
+		{"select: 1 2 3 4 5 0 1", 1, "too many arguments"},
+		{"orientation: upright", 1, "illegal argument 'upright'"},
+		{"reverse: on", 1, "illegal argument 'on'"},
+	}
+	for _, tc := range tests {
+		_, err := readConfigFile(writeConfig(t, tc.text))
+		var ce *ConfigError
+		if !errors.As(err, &ce) {
+			t.Errorf("%q: got error %v, want ConfigError", tc.text, err)
+			continue
+		}
+		if ce.Line != tc.line || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got %v, want line %d, %s", tc.text, err, tc.line, tc.err)
+		}
+	}
+}
+
+// TestReadConfigDefaults checks the merging of the settings in the defaults files and the config file.
+func TestReadConfigDefaults(t *testing.T) {
+	tests := []struct {
+		defaults, config string
+		rating           string
+		sel              []string
+		large, nocase    bool
+		include          []string
+		err              string
+	}{
+		{defaults: "rating: 3\nlarge:", config: "select: 4 5", sel: []string{"4", "5"}, large: true},
+		{defaults: "select: 4 5", config: "rating: 2", rating: "2"},
+		{defaults: "rating: 3", config: "include: *.JPG\nnocase:", rating: "3", include: []string{"*.JPG"}, nocase: true},
+		{defaults: "large:\nnocase:\ninclude: *.JPG", config: "large: off\nnocase: off", include: []string{"*.JPG"}},
+		{defaults: "large: off", config: "large:", large: true},
+		{config: "rating: 3\nselect: 4", err: "cannot use both select and rating"},
+	}
+	for _, tc := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, DefaultsFile), []byte(tc.defaults), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f := filepath.Join(dir, "web")
+		if err := os.WriteFile(f, []byte("dir: x\n"+tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gc, err := LoadConfig(f)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q, %q: got error %v, want %q", tc.defaults, tc.config, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q, %q: %v", tc.defaults, tc.config, err)
+			continue
+		}
+		if tc.include == nil {
+			// The default include list is matched without regard to case.
+			tc.include, tc.nocase = []string{"*.jpg", "*.jpeg"}, true
+		}
+		if gc.Rating != tc.rating || !slices.Equal(gc.Select, tc.sel) || gc.Large != tc.large ||
+			gc.NoCase != tc.nocase || !slices.Equal(gc.Include, tc.include) {
+			t.Errorf("%q, %q: got rating %q, select %q, large %v, nocase %v, include %q", tc.defaults, tc.config,
+				gc.Rating, gc.Select, gc.Large, gc.NoCase, gc.Include)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"fmt"
+)
+
+// ConfigError is an error in the gallery configuration.
+type ConfigError struct {
+	File    string // Config file, if known
+	Line    int    // Line number in the config file, if known
+	Keyword string // Config keyword, if known
+	Err     error
+}
+
+func (e *ConfigError) Error() string {
+	s := e.Err.Error()
+	if e.Keyword != "" {
+		s = fmt.Sprintf("%s: %s", e.Keyword, s)
+	}
+	if e.Line != 0 {
+		s = fmt.Sprintf("line %d, %s", e.Line, s)
+	}
+	if e.File != "" {
+		s = fmt.Sprintf("%s: %s", e.File, s)
+	}
+	return s
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ImageError is an error processing a single image.
+type ImageError struct {
+	File string // Source file of the image
+	Op   string // Operation that failed
+	Err  error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Op, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,142 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/pweb/exif"
+	"github.com/aamcrae/pweb/exif/exiv2"
+	"github.com/aamcrae/pweb/exif/goexif"
+)
+
+// date/time layouts for the EXIF date objects.
+const (
+	canonLayout = "2006:01:02 15:04:05"
+	stdLayout   = "2006-01-02 15:04:05"
+)
+
+// listSep separates the items of the list fields in the Exif fields.
+const listSep = "\n"
+
+// Metadata fields that are lists, such as the keywords, which are stored with
+// the items separated by listSep.
+var listFields = map[string]bool{"keyword": true}
+
+// Exif holds the EXIF date read from a file.
+type Exif struct {
+	title       string
+	caption     string
+	orientation string
+	ts          time.Time
+	rating      string
+	iso         string
+	exposure    string
+	fstop       string
+	focal_len   string
+	width       int
+	height      int
+	fields      map[string]string // Additional metadata used in templates
+}
+
+// Function to open a metadata reader using a particular backend
+type NewMetadata func(src string) (exif.MetadataReader, error)
+
+// Metadata is a backend used to read the image metadata.
+type Metadata struct {
+	Name string      // Name of the backend, recorded in the EXIF cache
+	Open NewMetadata // Opens the metadata of an image
+}
+
+// SelectMetadata returns one of the metadata readers, either "exiv2" or "goexif".
+func SelectMetadata(name string) (*Metadata, error) {
+	switch name {
+	case "exiv2":
+		return &Metadata{Name: name, Open: exiv2.Exiv2Open}, nil
+	case "goexif":
+		return &Metadata{Name: name, Open: goexif.GoExifOpen}, nil
+	}
+	return nil, fmt.Errorf("%s: Unknown metadata reader", name)
+}
+
+// ReadExif reads the file and extracts the EXIF data from the file.
+// If a XMP sidecar file is provided, the metadata is merged according to the sidecar mode.
+func ReadExif(open NewMetadata, srcFile, sidecar string, sidecarMode int) (*Exif, error) {
+	return readExif(open, srcFile, sidecar, sidecarMode, nil)
+}
+
+// readExif reads the EXIF data, along with the additional fields used in templates.
+func readExif(open NewMetadata, srcFile, sidecar string, sidecarMode int, fields []metaField) (*Exif, error) {
+	reader, err := open(srcFile)
+	if err != nil {
+		return nil, err
+	}
+	if sidecar != "" || sidecarMode == SIDECAR_ONLY {
+		if reader, err = newSidecarReader(reader, sidecar, sidecarMode == SIDECAR_ONLY); err != nil {
+			return nil, fmt.Errorf("%s: %w", sidecar, err)
+		}
+	}
+	var exif Exif
+	exif.title = reader.Get("Iptc.Application2.ObjectName", "Iptc.Application2.Headline", "Iptc.Application2.Caption")
+	exif.caption = reader.Get("Iptc.Application2.Caption")
+	exif.exposure = reader.Get("Exif.Photo.ExposureTime")
+	exif.iso = reader.Get("Exif.Photo.ISOSpeedRatings")
+	exif.fstop = rational(reader.Get("Exif.Photo.FNumber"))
+	exif.focal_len = rational(reader.Get("Exif.Photo.FocalLength"))
+	exif.orientation = reader.Get("Exif.Image.Orientation")
+	if w, err := strconv.Atoi(reader.Get("Xmp.tiff.ImageWidth", "Exif.Photo.PixelXDimension")); err == nil {
+		exif.width = w
+	}
+	if h, err := strconv.Atoi(reader.Get("Xmp.tiff.ImageLength", "Exif.Photo.PixelYDimension")); err == nil {
+		exif.height = h
+	}
+	date := reader.Get("Exif.Photo.DateTimeDigitized", "Exif.Photo.DateTimeOriginal", "Exif.Image.DateTime")
+	if len(date) > 0 {
+		var err error
+		// The date should be in ISO 8601 format, but Canon uses ':' instead of '-'
+		if exif.ts, err = time.ParseInLocation(stdLayout, date, time.Local); err != nil {
+			if exif.ts, err = time.ParseInLocation(canonLayout, date, time.Local); err != nil {
+				fmt.Printf("Unable to parse date (%s): %v\n", date, err)
+			}
+		}
+	}
+	exif.rating = reader.Get("Xmp.xmp.Rating")
+	for _, f := range fields {
+		var v string
+		if listFields[f.name] {
+			v = strings.Join(reader.GetList(f.keys...), listSep)
+		} else {
+			v = reader.Get(f.keys...)
+		}
+		if v != "" {
+			if exif.fields == nil {
+				exif.fields = make(map[string]string)
+			}
+			exif.fields[f.name] = v
+		}
+	}
+	return &exif, nil
+}
+
+// Convert rational to FP
+func rational(in string) string {
+	if len(in) == 0 {
+		return ""
+	}
+	var v1, v2 float64
+	n, err := fmt.Sscanf(in, "%f/%f", &v1, &v2)
+	if err != nil || n != 2 {
+		fmt.Printf("Failed to convert EXIF ration value %s\n", in)
+		return in
+	} else {
+		s := strconv.FormatFloat(v1/v2, 'f', 2, 64)
+		s = strings.TrimRight(s, "0")
+		// Check for no trailing digits
+		last := len(s) - 1
+		if s[last] == '.' {
+			s = s[:last]
+		}
+		return s
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !noexiv2
+
+package builder
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aamcrae/pweb/exif/exiv2"
+	"github.com/aamcrae/pweb/exif/goexif"
+)
+
+// TestExifParity checks that the exiv2 and goexif backends return the same
+// metadata for the example photos.
+func TestExifParity(t *testing.T) {
+	files, err := filepath.Glob("../example/photos/*.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("no example photos")
+	}
+	// Read the additional fields used by the templates and filters as well.
+	var fields []metaField
+	for _, f := range templateFields {
+		fields = append(fields, f)
+	}
+	for _, f := range filterFields {
+		fields = append(fields, f)
+	}
+	slices.SortFunc(fields, func(a, b metaField) int {
+		return strings.Compare(a.name, b.name)
+	})
+	for _, f := range files {
+		want, err := readExif(exiv2.Exiv2Open, f, "", SIDECAR_IGNORE, fields)
+		if err != nil {
+			t.Fatalf("%s: exiv2: %v", f, err)
+		}
+		got, err := readExif(goexif.GoExifOpen, f, "", SIDECAR_IGNORE, fields)
+		if err != nil {
+			t.Fatalf("%s: goexif: %v", f, err)
+		}
+		if !got.ts.Equal(want.ts) {
+			t.Errorf("%s: date: exiv2 %v, goexif %v", f, want.ts, got.ts)
+		}
+		want.ts, got.ts = want.ts.UTC(), got.ts.UTC()
+		if !reflect.DeepEqual(*got, *want) {
+			t.Errorf("%s:\nexiv2  %+v\ngoexif %+v", f, *want, *got)
+		}
+	}
+}
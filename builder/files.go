@@ -0,0 +1,259 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/thomasheller/braceexpansion"
+)
+
+// globFiles expands the wildcard file list, and returns
+// the list of files matching the wildcards. Relative wildcards are
+// matched in the directory provided, and the matching files are returned
+// relative to that directory. A "**" path element matches any number of
+// directories (hidden directories are skipped). If nocase is set, the names
+// are matched without regard to case.
+// The files matching each wildcard are sorted by pathname, and the wildcards
+// are expanded in order. A file matching more than one wildcard is only listed once.
+func globFiles(dir string, in []string, nocase bool) ([]string, error) {
+	var files []string
+	seen := make(map[string]struct{})
+	for _, f := range in {
+		tree, err := braceexpansion.New().Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, exp := range tree.Expand() {
+			base := dir
+			if filepath.IsAbs(exp) {
+				base = string(filepath.Separator)
+			}
+			// Check the pattern is valid, since an invalid pattern may not be matched against anything.
+			if _, err := filepath.Match(exp, ""); err != nil {
+				return nil, err
+			}
+			var fl []string
+			segs := strings.Split(filepath.Clean(exp), string(filepath.Separator))
+			if err := match(base, "", segs, nocase, &fl); err != nil {
+				return nil, err
+			}
+			slices.Sort(fl)
+			for _, f := range fl {
+				if filepath.IsAbs(exp) {
+					f = filepath.Join(base, f)
+				}
+				if _, ok := seen[f]; !ok {
+					seen[f] = struct{}{}
+					files = append(files, f)
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// match appends to files the pathnames (relative to base) of the files that match the
+// remaining path elements of the pattern, starting at the directory rel.
+func match(base, rel string, segs []string, nocase bool, files *[]string) error {
+	if len(segs) == 0 {
+		return nil
+	}
+	seg := segs[0]
+	if seg == "" {
+		// Leading separator of an absolute pattern.
+		return match(base, rel, segs[1:], nocase, files)
+	}
+	if seg == "." {
+		return match(base, rel, segs[1:], nocase, files)
+	}
+	if seg == ".." {
+		return match(base, filepath.Join(rel, seg), segs[1:], nocase, files)
+	}
+	dentries, err := os.ReadDir(filepath.Join(base, rel))
+	if err != nil {
+		// Directories that do not exist (or are not directories) match nothing.
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			return nil
+		}
+		return err
+	}
+	if seg == "**" {
+		if len(segs) == 1 {
+			// A trailing "**" matches all the files in the directories.
+			segs = []string{"**", "*"}
+		}
+		// Match zero directories, and then each of the subdirectories.
+		if err := match(base, rel, segs[1:], nocase, files); err != nil {
+			return err
+		}
+		for _, d := range dentries {
+			if d.IsDir() && !strings.HasPrefix(d.Name(), ".") {
+				if err := match(base, filepath.Join(rel, d.Name()), segs, nocase, files); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	pattern := seg
+	if nocase {
+		pattern = strings.ToLower(seg)
+	}
+	for _, d := range dentries {
+		name := d.Name()
+		if nocase {
+			name = strings.ToLower(name)
+		}
+		if ok, _ := filepath.Match(pattern, name); !ok {
+			continue
+		}
+		p := filepath.Join(rel, d.Name())
+		// Follow symlinks to determine the type of the file.
+		st, err := os.Stat(filepath.Join(base, p))
+		if err != nil {
+			continue
+		}
+		if len(segs) == 1 {
+			if !st.IsDir() {
+				*files = append(*files, p)
+			}
+		} else if st.IsDir() {
+			if err := match(base, p, segs[1:], nocase, files); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Add the list of file names to an existing list, using the first
+// filename in each entry as an anchor. The list may be added
+// before the anchor, or after, depending on the argument.
+func insert(dir string, flist []string, list [][]string, before, nocase bool) ([]string, error) {
+	m := make(map[string][]string)
+	for _, il := range list {
+		m[il[0]] = append(m[il[0]], il[1:]...)
+	}
+	var newFiles []string
+	for _, f := range flist {
+		if v, ok := m[f]; ok {
+			if before {
+				fl, err := globFiles(dir, v, nocase)
+				if err != nil {
+					return nil, err
+				}
+				newFiles = append(newFiles, fl...)
+			}
+			newFiles = append(newFiles, f)
+			if !before {
+				fl, err := globFiles(dir, v, nocase)
+				if err != nil {
+					return nil, err
+				}
+				newFiles = append(newFiles, fl...)
+			}
+			delete(m, f)
+		} else {
+			newFiles = append(newFiles, f)
+		}
+	}
+	// Check if any files left over.
+	if len(m) != 0 {
+		return nil, fmt.Errorf("could not locate: %s", strings.Join(slices.Collect(maps.Keys(m)), " "))
+	}
+	return newFiles, nil
+}
+
+// find does a linear search of the list for the requested filename.
+func find(list []string, name string) (int, bool) {
+	for i, v := range list {
+		if v == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// makeDirs ensures that the directories passed exist, creating them if necessary.
+func makeDirs(dir ...string) error {
+	for _, d := range dir {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cpMaybe will copy the file if the source exists
+func cpMaybe(src, dst string) error {
+	_, err := os.Stat(src)
+	if err == nil {
+		return cpFile(src, dst)
+	}
+	// No error if source does not exist
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// cpFile will copy the src file to the dst filename if the
+// modify time is different.
+func cpFile(src, dst string) error {
+	if st, err := getMtime(src); err != nil {
+		return err
+	} else {
+		if dt, err := getMtime(dst); err == nil && dt == st {
+			// mtimes are the same
+			return nil
+		} else {
+			// File is either non-existent or out of date
+			if b, err := os.ReadFile(src); err != nil {
+				return err
+			} else {
+				return cp(b, dst, st)
+			}
+		}
+	}
+}
+
+// cpBytes will copy the src data to the dst filename if the
+// modify time is different.
+func cpBytes(src []byte, mtime time.Time, dst string) error {
+	if t, err := getMtime(dst); err == nil {
+		if t == mtime {
+			return nil
+		}
+	}
+	// dst file is either non-existent or a different time.
+	return cp(src, dst, mtime)
+}
+
+// cp copies the byte slice src to dest, and adjusts the mtime to match.
+func cp(src []byte, dst string, mtime time.Time) error {
+	if err := os.WriteFile(dst, src, 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+// getMtime gets the modified time of the file.
+// If an error is returned, it must be a not-existing file.
+func getMtime(f string) (time.Time, error) {
+	if fi, err := os.Stat(f); err != nil {
+		var zeroT time.Time
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return zeroT, err
+	} else {
+		return fi.ModTime(), nil
+	}
+}
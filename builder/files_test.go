@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.jpg", "B.JPG", "c.png", "day-1/img_1.jpg", "day-1/img_2.JPG",
+		"day-2/img_3.jpg", "day-2/sub/img_4.jpg", ".hidden/img_5.jpg"} {
+		f = filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "empty.jpg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.jpg", filepath.Join(dir, "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in     []string
+		nocase bool
+		want   []string
+		err    bool
+	}{
+		{in: []string{"*.jpg"}, want: []string{"a.jpg", "link.jpg"}},
+		{in: []string{"*.jpg"}, nocase: true, want: []string{"B.JPG", "a.jpg", "link.jpg"}},
+		{in: []string{"*"}, want: []string{"B.JPG", "a.jpg", "c.png", "link.jpg"}},
+		{in: []string{"c.png", "*"}, want: []string{"c.png", "B.JPG", "a.jpg", "link.jpg"}},
+		{in: []string{"day-*/*.jpg"}, want: []string{"day-1/img_1.jpg", "day-2/img_3.jpg"}},
+		{in: []string{"day-{2,1}/img_*"}, want: []string{"day-2/img_3.jpg", "day-1/img_1.jpg", "day-1/img_2.JPG"}},
+		{in: []string{"**/*.jpg"}, want: []string{"a.jpg", "day-1/img_1.jpg", "day-2/img_3.jpg", "day-2/sub/img_4.jpg", "link.jpg"}},
+		{in: []string{"**/img_[24].jpg"}, nocase: true, want: []string{"day-1/img_2.JPG", "day-2/sub/img_4.jpg"}},
+		{in: []string{"day-2/**"}, want: []string{"day-2/img_3.jpg", "day-2/sub/img_4.jpg"}},
+		{in: []string{".hidden/*"}, want: []string{".hidden/img_5.jpg"}},
+		{in: []string{"./day-1/../a.jpg"}, want: []string{"a.jpg"}},
+		{in: []string{"../" + filepath.Base(dir) + "/c.png"}, want: []string{"../" + filepath.Base(dir) + "/c.png"}},
+		{in: []string{filepath.Join(dir, "day-1", "*.jpg")}, want: []string{filepath.Join(dir, "day-1", "img_1.jpg")}},
+		{in: []string{"missing/*.jpg", "a.jpg/*", "*.gif"}},
+		{in: []string{"["}, err: true},
+	}
+	for _, tc := range tests {
+		got, err := globFiles(dir, tc.in, tc.nocase)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: no error", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q (nocase %v): got %q, want %q", tc.in, tc.nocase, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,612 @@
+package builder
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Orientations of the photos that may be selected.
+const (
+	ORIENT_ANY       = iota
+	ORIENT_PORTRAIT  // Height greater than the width
+	ORIENT_LANDSCAPE // Width greater than the height
+	ORIENT_SQUARE    // Width and height equal
+)
+
+// Metadata fields used by the filters.
+var filterFields = map[string]metaField{
+	"make":    {"make", []string{"Exif.Image.Make"}},
+	"model":   {"model", []string{"Exif.Image.Model"}},
+	"lens":    {"lens", []string{"Exif.Photo.LensModel", "Xmp.aux.Lens", "Xmp.exifEX.LensModel"}},
+	"keyword": {"keyword", []string{"Xmp.dc.subject", "Iptc.Application2.Keywords"}},
+	"label":   {"label", []string{"Xmp.xmp.Label"}},
+}
+
+// Layouts of the dates used in the filters.
+var filterDateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// picFilter selects the pictures of the gallery.
+type picFilter struct {
+	name   string                       // Name used when reporting the pictures excluded
+	fields []metaField                  // Metadata fields required by the filter
+	match  func(p *Pict) (bool, string) // Returns true if the picture is selected, and the value tested
+}
+
+// parseDate parses a date or time in one of the filter layouts, returning the start
+// of the period and the start of the following period e.g "2024-03" returns the
+// start of March and the start of April.
+func parseDate(s string) (time.Time, time.Time, error) {
+	for _, l := range filterDateLayouts {
+		t, err := time.ParseInLocation(l, s, time.Local)
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(l, ":05"):
+			return t, t.Add(time.Second), nil
+		case strings.HasSuffix(l, ":04"):
+			return t, t.Add(time.Minute), nil
+		case l == "2006-01-02":
+			return t, t.AddDate(0, 0, 1), nil
+		case l == "2006-01":
+			return t, t.AddDate(0, 1, 0), nil
+		}
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("bad date (%s)", s)
+}
+
+// checkWildcards checks that the patterns are valid wildcards.
+func checkWildcards(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// matchAny returns true if the value matches any of the wildcards, without regard to case.
+func matchAny(patterns []string, v string) bool {
+	v = strings.ToLower(v)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keywords returns the keywords of the picture.
+func (p *Pict) keywords() []string {
+	var kl []string
+	for _, k := range strings.Split(p.exif.fields["keyword"], listSep) {
+		if k = strings.TrimSpace(k); k != "" {
+			kl = append(kl, k)
+		}
+	}
+	return kl
+}
+
+// displaySize returns the size of the picture as displayed, reading the
+// image header if the size is not in the EXIF data.
+func (p *Pict) displaySize() (int, int) {
+	w, h := p.exif.width, p.exif.height
+	if w == 0 || h == 0 {
+		if f, err := os.Open(p.srcPath); err == nil {
+			if c, _, err := image.DecodeConfig(f); err == nil {
+				w, h = c.Width, c.Height
+			}
+			f.Close()
+		}
+	}
+	return displaySize(p.exif.orientation, w, h)
+}
+
+// orientation returns the orientation of the picture as displayed.
+func (p *Pict) orientation() int {
+	w, h := p.displaySize()
+	switch {
+	case w == 0 || h == 0:
+		return ORIENT_ANY
+	case w < h:
+		return ORIENT_PORTRAIT
+	case w > h:
+		return ORIENT_LANDSCAPE
+	}
+	return ORIENT_SQUARE
+}
+
+// orientNames are the names of the orientations.
+var orientNames = map[string]int{
+	"portrait":  ORIENT_PORTRAIT,
+	"landscape": ORIENT_LANDSCAPE,
+	"square":    ORIENT_SQUARE,
+}
+
+// orientName returns the name of the orientation.
+func orientName(o int) string {
+	for n, v := range orientNames {
+		if v == o {
+			return n
+		}
+	}
+	return "unknown"
+}
+
+// newFilters returns the filters selected by the configuration.
+func newFilters(conf *GalleryConfig) ([]picFilter, error) {
+	var fl []picFilter
+	if conf.Rating != "" && len(conf.Select) > 0 {
+		return nil, &ConfigError{Keyword: "select", Err: errors.New("cannot use both select and rating")}
+	}
+	// If a rating config is set, build a map of allowed ratings
+	// (either as a scale or as selected ratings).
+	if conf.Rating != "" || len(conf.Select) > 0 {
+		ratingMap := make(map[string]struct{})
+		for _, v := range rScaleMap[conf.Rating] {
+			ratingMap[v] = struct{}{}
+		}
+		for _, r := range conf.Select {
+			ratingMap[r] = struct{}{}
+		}
+		fl = append(fl, picFilter{name: "rating", match: func(p *Pict) (bool, string) {
+			_, ok := ratingMap[p.exif.rating]
+			return ok, p.exif.rating
+		}})
+	}
+	if !conf.DateFrom.IsZero() {
+		fl = append(fl, picFilter{name: "date-from", match: func(p *Pict) (bool, string) {
+			return !p.exif.ts.Before(conf.DateFrom), p.exif.ts.Format(stdLayout)
+		}})
+	}
+	if !conf.DateTo.IsZero() {
+		fl = append(fl, picFilter{name: "date-to", match: func(p *Pict) (bool, string) {
+			return p.exif.ts.Before(conf.DateTo), p.exif.ts.Format(stdLayout)
+		}})
+	}
+	fieldFilter := func(name, field string, patterns []string) {
+		if len(patterns) > 0 {
+			fl = append(fl, picFilter{name: name, fields: []metaField{filterFields[field]}, match: func(p *Pict) (bool, string) {
+				v := p.exif.fields[field]
+				return matchAny(patterns, v), v
+			}})
+		}
+	}
+	fieldFilter("camera", "model", conf.Camera)
+	fieldFilter("lens", "lens", conf.Lens)
+	fieldFilter("label", "label", conf.Label)
+	for _, kl := range conf.Keywords {
+		fl = append(fl, picFilter{name: "keyword", fields: []metaField{filterFields["keyword"]}, match: func(p *Pict) (bool, string) {
+			pk := p.keywords()
+			return slices.ContainsFunc(pk, func(k string) bool { return matchAny(kl, k) }), strings.Join(pk, ", ")
+		}})
+	}
+	if conf.Orientation != ORIENT_ANY {
+		fl = append(fl, picFilter{name: "orientation", match: func(p *Pict) (bool, string) {
+			o := p.orientation()
+			return o == conf.Orientation, orientName(o)
+		}})
+	}
+	for _, f := range conf.Filters {
+		e, err := parseFilter(f)
+		if err != nil {
+			return nil, &ConfigError{Keyword: "filter", Err: err}
+		}
+		fl = append(fl, picFilter{name: "filter", fields: e.fields(), match: func(p *Pict) (bool, string) {
+			return e.eval(p), f
+		}})
+	}
+	return fl, nil
+}
+
+// Filter expressions are comparisons of the picture's metadata, of the form
+// <field> <op> <value>, where the operator is one of ==, !=, <, <=, >, >= or ~ (wildcard match).
+// Comparisons may be combined using && and ||, negated using ! and grouped using parentheses.
+// Values containing spaces or operator characters must be quoted.
+
+// Types of the expression fields, which select how the values are compared.
+const (
+	fieldString = iota
+	fieldNumber
+	fieldDate
+	fieldList
+	fieldOrient
+)
+
+// exprFields are the fields that may be used in filter expressions.
+var exprFields = map[string]int{
+	"rating":      fieldNumber,
+	"iso":         fieldNumber,
+	"aperture":    fieldNumber,
+	"focal":       fieldNumber,
+	"exposure":    fieldNumber,
+	"width":       fieldNumber,
+	"height":      fieldNumber,
+	"date":        fieldDate,
+	"make":        fieldString,
+	"model":       fieldString,
+	"camera":      fieldString,
+	"lens":        fieldString,
+	"label":       fieldString,
+	"title":       fieldString,
+	"caption":     fieldString,
+	"name":        fieldString,
+	"keyword":     fieldList,
+	"orientation": fieldOrient,
+}
+
+// filterExpr is a node of a filter expression; either a comparison, or
+// an operator applied to the sub-expressions.
+type filterExpr struct {
+	op    string // Operator
+	field string // Field of a comparison
+	value string // Value of a comparison
+	num   float64
+	from  time.Time
+	to    time.Time
+	sub   []*filterExpr
+}
+
+// exprLexer splits the expression into tokens.
+type exprLexer struct {
+	s      string
+	tokens []string
+	quoted []bool
+}
+
+// Operators of the filter expressions, longest first.
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "~", "!", "(", ")"}
+
+// lex splits the expression into operators, names and values.
+func (l *exprLexer) lex() error {
+	s := l.s
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return nil
+		}
+		if i := slices.IndexFunc(exprOps, func(op string) bool { return strings.HasPrefix(s, op) }); i >= 0 {
+			l.tokens, l.quoted = append(l.tokens, exprOps[i]), append(l.quoted, false)
+			s = s[len(exprOps[i]):]
+			continue
+		}
+		if s[0] == '"' || s[0] == '\'' {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return errors.New("missing closing quote")
+			}
+			l.tokens, l.quoted = append(l.tokens, s[1:end+1]), append(l.quoted, true)
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t&|=!<>~()\"'")
+		if end < 0 {
+			end = len(s)
+		} else if end == 0 {
+			return fmt.Errorf("unexpected '%c' in filter", s[0])
+		}
+		l.tokens, l.quoted = append(l.tokens, s[:end]), append(l.quoted, false)
+		s = s[end:]
+	}
+}
+
+// exprParser is a recursive descent parser of the filter expressions.
+type exprParser struct {
+	exprLexer
+	pos int
+}
+
+// parseFilter parses the filter expression.
+func parseFilter(s string) (*filterExpr, error) {
+	p := &exprParser{exprLexer: exprLexer{s: s}}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in filter", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+// next returns the next token, and whether it is an operator.
+func (p *exprParser) next() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	t := p.tokens[p.pos]
+	return t, !p.quoted[p.pos] && slices.Contains(exprOps, t)
+}
+
+// accept consumes the next token if it is the operator.
+func (p *exprParser) accept(op string) bool {
+	if t, isOp := p.next(); isOp && t == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// or parses the expressions joined by ||.
+func (p *exprParser) or() (*filterExpr, error) {
+	return p.binary("||", p.and)
+}
+
+// and parses the expressions joined by &&.
+func (p *exprParser) and() (*filterExpr, error) {
+	return p.binary("&&", p.unary)
+}
+
+// binary parses the expressions joined by the operator.
+func (p *exprParser) binary(op string, operand func() (*filterExpr, error)) (*filterExpr, error) {
+	e, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(op) {
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if e.op != op {
+			e = &filterExpr{op: op, sub: []*filterExpr{e}}
+		}
+		e.sub = append(e.sub, r)
+	}
+	return e, nil
+}
+
+// unary parses a negated expression, an expression in parentheses or a comparison.
+func (p *exprParser) unary() (*filterExpr, error) {
+	if p.accept("!") {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterExpr{op: "!", sub: []*filterExpr{e}}, nil
+	}
+	if p.accept("(") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.New("missing ')' in filter")
+		}
+		return e, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses a comparison of a field with a value.
+func (p *exprParser) comparison() (*filterExpr, error) {
+	field, isOp := p.next()
+	if field == "" || isOp {
+		return nil, errors.New("missing field name in filter")
+	}
+	p.pos++
+	ftype, ok := exprFields[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter field (%s)", field)
+	}
+	op, isOp := p.next()
+	if !isOp || !slices.Contains([]string{"==", "!=", "<", "<=", ">", ">=", "~"}, op) {
+		return nil, fmt.Errorf("missing comparison after %s", field)
+	}
+	p.pos++
+	value, isOp := p.next()
+	if isOp || p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing value after %s %s", field, op)
+	}
+	p.pos++
+	e := &filterExpr{op: op, field: field, value: value}
+	var err error
+	switch ftype {
+	case fieldNumber:
+		if op == "~" {
+			return nil, fmt.Errorf("%s: wildcard match not supported", field)
+		}
+		if e.num, err = parseNumber(value); err != nil {
+			return nil, fmt.Errorf("%s: bad number (%s)", field, value)
+		}
+	case fieldDate:
+		if op == "~" {
+			return nil, fmt.Errorf("%s: wildcard match not supported", field)
+		}
+		if e.from, e.to, err = parseDate(value); err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+	case fieldOrient:
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("%s: only == and != are supported", field)
+		}
+		if _, ok := orientNames[strings.ToLower(value)]; !ok {
+			return nil, fmt.Errorf("%s: unknown orientation (%s)", field, value)
+		}
+	default:
+		if op != "==" && op != "!=" && op != "~" {
+			return nil, fmt.Errorf("%s: only ==, != and ~ are supported", field)
+		}
+		if op == "~" {
+			if err := checkWildcards([]string{value}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e, nil
+}
+
+// parseNumber parses a number, which may be a fraction such as an exposure of 1/250.
+func parseNumber(s string) (float64, error) {
+	if n, d, ok := strings.Cut(s, "/"); ok {
+		nv, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, err
+		}
+		dv, err := strconv.ParseFloat(d, 64)
+		if err != nil || dv == 0 {
+			return 0, errors.New("bad fraction")
+		}
+		return nv / dv, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// fields returns the metadata fields required by the expression.
+func (e *filterExpr) fields() []metaField {
+	var fl []metaField
+	if e.field != "" {
+		name := e.field
+		if name == "camera" {
+			name = "model"
+		}
+		if f, ok := filterFields[name]; ok {
+			fl = append(fl, f)
+		}
+	}
+	for _, s := range e.sub {
+		fl = append(fl, s.fields()...)
+	}
+	return fl
+}
+
+// eval returns the result of the expression for the picture.
+func (e *filterExpr) eval(p *Pict) bool {
+	switch e.op {
+	case "&&":
+		for _, s := range e.sub {
+			if !s.eval(p) {
+				return false
+			}
+		}
+		return true
+	case "||":
+		for _, s := range e.sub {
+			if s.eval(p) {
+				return true
+			}
+		}
+		return false
+	case "!":
+		return !e.sub[0].eval(p)
+	}
+	switch exprFields[e.field] {
+	case fieldNumber:
+		v, ok := p.number(e.field)
+		if !ok {
+			// Pictures without the value only match !=.
+			return e.op == "!="
+		}
+		return compare(e.op, cmp.Compare(v, e.num))
+	case fieldDate:
+		ts := p.exif.ts
+		switch e.op {
+		case "==":
+			return !ts.Before(e.from) && ts.Before(e.to)
+		case "!=":
+			return ts.Before(e.from) || !ts.Before(e.to)
+		case "<":
+			return ts.Before(e.from)
+		case "<=":
+			return ts.Before(e.to)
+		case ">":
+			return !ts.Before(e.to)
+		}
+		return !ts.Before(e.from)
+	case fieldOrient:
+		return (p.orientation() == orientNames[strings.ToLower(e.value)]) == (e.op == "==")
+	case fieldList:
+		found := slices.ContainsFunc(p.keywords(), func(k string) bool { return e.matchString(k) })
+		return found == (e.op != "!=")
+	}
+	return e.matchString(p.stringField(e.field)) == (e.op != "!=")
+}
+
+// matchString returns true if the value is equal to (or for ~, matches) the expression value,
+// without regard to case.
+func (e *filterExpr) matchString(v string) bool {
+	if e.op == "~" {
+		return matchAny([]string{e.value}, v)
+	}
+	return strings.EqualFold(v, e.value)
+}
+
+// compare returns the result of the comparison operator, given the result of comparing the values.
+func compare(op string, c int) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// number returns the value of a numeric field of the picture. Unrated pictures have a rating of 0.
+func (p *Pict) number(field string) (float64, bool) {
+	exif := p.exif
+	var s string
+	switch field {
+	case "rating":
+		if s = exif.rating; s == "" {
+			return 0, true
+		}
+	case "iso":
+		s = exif.iso
+	case "aperture":
+		s = exif.fstop
+	case "focal":
+		s = exif.focal_len
+	case "exposure":
+		s = exif.exposure
+	case "width", "height":
+		w, h := p.displaySize()
+		if field == "height" {
+			w = h
+		}
+		return float64(w), w != 0
+	}
+	v, err := parseNumber(strings.TrimSpace(s))
+	return v, err == nil
+}
+
+// stringField returns the value of a string field of the picture.
+func (p *Pict) stringField(field string) string {
+	switch field {
+	case "title":
+		return p.exif.title
+	case "caption":
+		return p.exif.caption
+	case "name":
+		return p.baseName
+	case "camera":
+		field = "model"
+	}
+	return p.exif.fields[field]
+}
@@ -0,0 +1,176 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPicts returns pictures with metadata used to test the filters.
+func testPicts() []*Pict {
+	return []*Pict{
+		{srcFile: "IMG_2.jpg", baseName: "IMG_2.jpg", exif: &Exif{
+			title: "Summit at dawn", caption: "Low's Peak", orientation: "1",
+			ts: time.Date(2024, 3, 10, 6, 30, 0, 0, time.Local), rating: "4",
+			iso: "200", exposure: "1/250", fstop: "5.6", focal_len: "50", width: 3000, height: 2000,
+			fields: map[string]string{"make": "FUJIFILM", "model": "X-T5", "lens": "XF16-55mmF2.8 R LM WR",
+				"keyword": "Birds\nSabah\nTawau, Sabah", "label": "Red"},
+		}},
+		{srcFile: "IMG_10.jpg", baseName: "IMG_10.jpg", exif: &Exif{
+			orientation: "6", ts: time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local),
+			iso: "3200", width: 3000, height: 2000,
+			fields: map[string]string{"make": "Canon", "model": "Canon EOS R5"},
+		}},
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "rating >= 3"},
+		{expr: `(rating>=4 || keyword == favourite) && !(lens ~ "*Zoom*")`},
+		{expr: "exposure <= 1/250 && date == 2024-03 && orientation == Portrait"},
+		{expr: "title == '&& ||'"},
+		{expr: "", err: "missing field name"},
+		{expr: "rating", err: "missing comparison after rating"},
+		{expr: "rating >=", err: "missing value after rating >="},
+		{expr: "rating >= (", err: "missing value after rating >="},
+		{expr: "colour == red", err: "unknown filter field (colour)"},
+		{expr: "rating ~ 3", err: "wildcard match not supported"},
+		{expr: "rating > high", err: "bad number (high)"},
+		{expr: "exposure > 1/0", err: "bad number (1/0)"},
+		{expr: "date ~ 2024", err: "wildcard match not supported"},
+		{expr: "date > March", err: "bad date (March)"},
+		{expr: "orientation > portrait", err: "only == and != are supported"},
+		{expr: "orientation == upright", err: "unknown orientation (upright)"},
+		{expr: "model < X", err: "only ==, != and ~ are supported"},
+		{expr: "lens ~ [", err: "syntax error in pattern"},
+		{expr: `title == "abc`, err: "missing closing quote"},
+		{expr: "(rating > 1", err: "missing ')'"},
+		{expr: "rating > 1)", err: "unexpected ')'"},
+		{expr: "rating > 1 &", err: "unexpected '&'"},
+		{expr: "rating > 1 rating", err: "unexpected 'rating'"},
+	}
+	for _, tc := range tests {
+		_, err := parseFilter(tc.expr)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tc.expr, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, want %q", tc.expr, err, tc.err)
+		}
+	}
+}
+
+func TestFilterEval(t *testing.T) {
+	picts := testPicts()
+	tests := []struct {
+		expr string
+		want [2]bool // Result for each of the test pictures
+	}{
+		{"rating >= 3", [2]bool{true, false}},
+		{"rating == 0", [2]bool{false, true}},
+		{"iso > 200", [2]bool{false, true}},
+		{"iso != 200", [2]bool{false, true}},
+		{"aperture < 8 && focal == 50", [2]bool{true, false}},
+		{"exposure <= 1/250", [2]bool{true, false}},
+		{"exposure != 1/250", [2]bool{false, true}},
+		{"width > 2500", [2]bool{true, false}},
+		{"date == 2024-03", [2]bool{true, false}},
+		{"date < 2024", [2]bool{false, true}},
+		{"date <= 2023-12-31", [2]bool{false, true}},
+		{`date > "2024-03-10 06:00"`, [2]bool{true, false}},
+		{"date >= '2024-03-10 06:30:00'", [2]bool{true, false}},
+		{"date != 2024", [2]bool{false, true}},
+		{"make == fujifilm", [2]bool{true, false}},
+		{`camera ~ "*EOS R*"`, [2]bool{false, true}},
+		{"model != X-T5", [2]bool{false, true}},
+		{`lens ~ "XF16-55*"`, [2]bool{true, false}},
+		{"label == red", [2]bool{true, false}},
+		{"title ~ summit*", [2]bool{true, false}},
+		{`caption == "low's peak"`, [2]bool{true, false}},
+		{"name ~ IMG_1*", [2]bool{false, true}},
+		{"keyword == sabah", [2]bool{true, false}},
+		{"keyword ~ bird*", [2]bool{true, false}},
+		{"keyword != sabah", [2]bool{false, true}},
+		{`keyword == "tawau, sabah"`, [2]bool{true, false}},
+		{"keyword == tawau", [2]bool{false, false}},
+		{"orientation == landscape", [2]bool{true, false}},
+		{"orientation != landscape", [2]bool{false, true}},
+		{"rating >= 4 || iso >= 3200", [2]bool{true, true}},
+		{"rating >= 4 && iso >= 3200", [2]bool{false, false}},
+		{"!(rating >= 4) && !keyword == birds", [2]bool{false, true}},
+		{"(make == canon || rating > 3) && orientation == portrait", [2]bool{false, true}},
+	}
+	for _, tc := range tests {
+		e, err := parseFilter(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		for i, p := range picts {
+			if got := e.eval(p); got != tc.want[i] {
+				t.Errorf("%q: %s: got %v, want %v", tc.expr, p.srcFile, got, tc.want[i])
+			}
+		}
+	}
+}
+
+// TestConfigFilters checks the filters selected by the config file keywords.
+func TestConfigFilters(t *testing.T) {
+	picts := testPicts()
+	tests := []struct {
+		config string
+		want   [2]bool
+		err    string
+	}{
+		{config: "rating: 3", want: [2]bool{true, false}},
+		{config: "select: 0 4", want: [2]bool{true, false}},
+		{config: "date-from: 2024", want: [2]bool{true, false}},
+		{config: "date-to: 2023-12", want: [2]bool{false, true}},
+		{config: "date-from: 2023-12-31\ndate-to: 2024-03-10", want: [2]bool{true, true}},
+		{config: `camera: X-T1 "*eos*"`, want: [2]bool{false, true}},
+		{config: "lens: *16-55*", want: [2]bool{true, false}},
+		{config: "keyword: birds\nkeyword: sabah", want: [2]bool{true, false}},
+		{config: "keyword: birds\nkeyword: tawau", want: [2]bool{false, false}},
+		{config: `keyword: "tawau, sabah"`, want: [2]bool{true, false}},
+		{config: "label: Green Red", want: [2]bool{true, false}},
+		{config: "orientation: portrait", want: [2]bool{false, true}},
+		{config: "filter: iso >= 200\nfilter: rating == 0", want: [2]bool{false, true}},
+		{config: "date-from: 2024-03\ndate-to: 2024-02", err: "date-to is before date-from"},
+		{config: "date-from: March", err: "bad date (March)"},
+		{config: "camera: [", err: "syntax error in pattern"},
+		{config: "filter: rating > ", err: "missing value"},
+	}
+	for _, tc := range tests {
+		gc, err := LoadConfig(writeConfig(t, "dir: x\n"+tc.config))
+		var fl []picFilter
+		if err == nil {
+			fl, err = newFilters(gc)
+		}
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: got error %v, want %q", tc.config, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.config, err)
+			continue
+		}
+		for i, p := range picts {
+			got := true
+			for _, f := range fl {
+				if ok, _ := f.match(p); !ok {
+					got = false
+				}
+			}
+			if got != tc.want[i] {
+				t.Errorf("%q: %s: got %v, want %v", tc.config, p.srcFile, got, tc.want[i])
+			}
+		}
+	}
+}
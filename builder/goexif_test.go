@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/aamcrae/pweb/exif/goexif"
+)
+
+// TestGoExif checks the metadata read by the goexif backend from some of the example photos.
+func TestGoExif(t *testing.T) {
+	type values struct {
+		title, rating, date, focal, iso string
+		width, height                   int
+		model                           string
+	}
+	tests := []struct {
+		file string
+		want values
+	}{
+		{"crw_3662.jpg", values{"", "", "2004-10-09 11:38:20", "55", "100", 3072, 2048, "Canon EOS DIGITAL REBEL"}},
+		{"crw_3665.jpg", values{"", "", "2004-10-09 11:39:06", "55", "100", 1444, 1188, "Canon EOS DIGITAL REBEL"}},
+		{"crw_3689.jpg", values{"Flower 10", "4", "2004-10-09 11:54:24", "50", "100", 3072, 2048, "Canon EOS DIGITAL REBEL"}},
+	}
+	for _, tc := range tests {
+		e, err := readExif(goexif.GoExifOpen, "../example/photos/"+tc.file, "", SIDECAR_IGNORE, []metaField{filterFields["model"]})
+		if err != nil {
+			t.Errorf("%s: %v", tc.file, err)
+			continue
+		}
+		got := values{e.title, e.rating, e.ts.Format(stdLayout), e.focal_len, e.iso, e.width, e.height, e.fields["model"]}
+		if got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.file, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/aamcrae/pweb/imager"
+	"github.com/aamcrae/pweb/imager/dis"
+	"github.com/aamcrae/pweb/imager/vips"
+	"github.com/aamcrae/pweb/shared"
+)
+
+// Function to create an image using a particular processor
+type NewImage func(src string) (imager.Image, error)
+
+// Function to read an image at a reduced resolution of at least the minimum size,
+// given the size of the full image. The method used to read the image is returned.
+type NewReducedImage func(src string, full, min imager.Size) (imager.Image, string, error)
+
+// rendition is a scaled version of an image, written to a gallery subdirectory.
+type rendition struct {
+	dir     string // Subdirectory of the gallery, or "" for the main image
+	width   int
+	height  int
+	quality int
+	format  imager.Format
+}
+
+// filename returns the filename of the image written in this rendition's format.
+func (r rendition) filename(name string) string {
+	if r.format == imager.JPEG {
+		return name
+	}
+	return shared.FormatFile(name, r.format.String())
+}
+
+// Imager is an image processor used to generate the scaled images.
+type Imager struct {
+	Name string   // Name of the processor, recorded in the build manifest
+	Open NewImage // Reads and decodes an image
+	// Reads an image at a reduced resolution, used when fast thumbnails are enabled.
+	OpenReduced NewReducedImage
+	Formats     []imager.Format // Formats that the processor can write
+	// Estimated memory used per pixel of the original image, used to limit
+	// the number of images processed concurrently.
+	BytesPerPixel int
+}
+
+// SelectImager returns one of the image processors, either "dis" or "vips".
+func SelectImager(name string) (*Imager, error) {
+	switch name {
+	case "vips":
+		vips.VipsInit()
+		return &Imager{Name: name, Open: vips.NewVipsImage, OpenReduced: vips.NewVipsReduced, Formats: vips.Formats, BytesPerPixel: 6}, nil
+	case "dis":
+		return &Imager{Name: name, Open: dis.NewDisImage, OpenReduced: dis.NewDisReduced, Formats: dis.Formats, BytesPerPixel: 8}, nil
+	}
+	return nil, fmt.Errorf("%s: Unknown imager", name)
+}
+
+// orient transforms the image according to the EXIF orientation value, so
+// that the image is displayed the right way up.
+func orient(img imager.Image, orientation string) error {
+	switch orientation {
+	case "2":
+		return img.Flip(imager.FlipHorizontal)
+	case "3":
+		return img.Rotate(imager.Rotate180)
+	case "4":
+		return img.Flip(imager.FlipVertical)
+	case "5":
+		return img.Flip(imager.FlipTranspose)
+	case "6":
+		return img.Rotate(imager.Rotate270)
+	case "7":
+		return img.Flip(imager.FlipTransverse)
+	case "8":
+		return img.Rotate(imager.Rotate90)
+	}
+	return nil
+}
+
+// displaySize returns the width and height of the image once the
+// orientation is applied, swapping the dimensions if the image is rotated 90 degrees.
+func displaySize(orientation string, w, h int) (int, int) {
+	switch orientation {
+	case "5", "6", "7", "8":
+		return h, w
+	}
+	return w, h
+}
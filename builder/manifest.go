@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// Name of the build manifest file in the gallery directory.
+const manifestFile = ".pweb-manifest.json"
+
+// manifestVersion is incremented when the way images are generated changes,
+// so that all images are rebuilt.
+const manifestVersion = 1
+
+// manifestEntry records the source and parameters used to generate a single image file.
+type manifestEntry struct {
+	Source string    `json:"source"`
+	Mtime  time.Time `json:"mtime"`
+	Size   int64     `json:"size"`
+	Params string    `json:"params"` // Fingerprint of the rendering parameters
+	Width  int       `json:"width"`  // Original resolution of the source
+	Height int       `json:"height"`
+}
+
+// buildManifest records every image file written to the gallery, so that
+// only the images where the source or the rendering parameters have changed are rebuilt.
+// The manifest is rewritten on each build, containing only the current images.
+type buildManifest struct {
+	mu      sync.Mutex
+	destDir string
+	imager  string
+	old     map[string]manifestEntry
+	Version int                      `json:"version"`
+	Files   map[string]manifestEntry `json:"files"`
+}
+
+// readManifest reads the build manifest of the gallery. If the manifest does not exist
+// or is from a different version, an empty manifest is used (so all images are rebuilt).
+func readManifest(destDir, imager string) *buildManifest {
+	m := &buildManifest{destDir: destDir, imager: imager, Version: manifestVersion, Files: make(map[string]manifestEntry)}
+	var old buildManifest
+	if err := readMeta(path.Join(destDir, manifestFile), &old); err == nil && old.Version == manifestVersion {
+		m.old = old.Files
+	}
+	return m
+}
+
+// entry returns the manifest entry expected for the rendition of the picture.
+// Only the image file is recorded, since a change to the metadata in a sidecar file
+// does not change the rendered images. Renditions scaled from an image read at a reduced
+// resolution (see Options.FastThumbs) have a different fingerprint, so that they are
+// rebuilt from the full image when fast thumbnails are turned off.
+func (m *buildManifest) entry(p *Pict, r rendition, orientation string, reduced bool) manifestEntry {
+	h := sha256.New()
+	fmt.Fprintf(h, "%dx%d q%d %s %s o%s", r.width, r.height, r.quality, r.format, m.imager, orientation)
+	if reduced {
+		fmt.Fprint(h, " reduced")
+	}
+	return manifestEntry{
+		Source: p.srcFile,
+		Mtime:  p.mtime,
+		Size:   p.size,
+		Params: hex.EncodeToString(h.Sum(nil))[:16],
+	}
+}
+
+// current checks whether the file (relative to the gallery directory) was previously
+// built with the same source and parameters, and still exists.
+// The previous entry is returned if so.
+func (m *buildManifest) current(file string, e manifestEntry) (manifestEntry, bool) {
+	m.mu.Lock()
+	old, ok := m.old[file]
+	m.mu.Unlock()
+	if !ok || old.Source != e.Source || !old.Mtime.Equal(e.Mtime) || old.Size != e.Size || old.Params != e.Params {
+		return old, false
+	}
+	if _, err := os.Stat(path.Join(m.destDir, file)); err != nil {
+		return old, false
+	}
+	return old, true
+}
+
+// add records the file in the manifest.
+func (m *buildManifest) add(file string, e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files[file] = e
+}
+
+// write saves the manifest to the gallery directory.
+func (m *buildManifest) write() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeMeta(path.Join(m.destDir, manifestFile), m)
+}
@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// outputNames returns the names used for the gallery images and downloads of the
+// source files, keyed by the source file. The directories of a file are prepended
+// to the filename (separated by "_"), and characters that are not safe in URLs are replaced.
+// Names that collide (ignoring case and the extension, since the images of non-JPEG files are
+// written as JPEG) are made unique by appending a short hash of the source path to all but the
+// first of them (in the order of the source paths), so that the names do not depend on the order
+// of the files, and an image that is already published keeps its name when a file that sorts
+// after it is added.
+func outputNames(files []string) map[string]string {
+	names := make(map[string]string)
+	used := make(map[string][]string)
+	for _, f := range files {
+		n := safeName(flatten(f))
+		names[f] = n
+		key := strings.ToLower(stem(n))
+		used[key] = append(used[key], f)
+	}
+	for _, fl := range used {
+		if len(fl) < 2 {
+			continue
+		}
+		slices.Sort(fl)
+		for _, f := range fl[1:] {
+			n := names[f]
+			h := sha256.Sum256([]byte(f))
+			names[f] = stem(n) + "-" + hex.EncodeToString(h[:])[:8] + path.Ext(n)
+		}
+	}
+	return names
+}
+
+// flatten returns the filename prefixed with its directories
+// (of a relative or absolute path) e.g day1/img.jpg becomes day1_img.jpg.
+func flatten(fname string) string {
+	d, name := path.Split(fname)
+	for d != "" && d != "/" {
+		var f string
+		d = path.Dir(d)
+		d, f = path.Split(d)
+		name = f + "_" + name
+	}
+	return name
+}
+
+// safeName replaces the characters in the name that are not letters, digits, '.', '-' or '_'
+// with '_', so that the name does not need escaping in URLs or srcset attributes.
+func safeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
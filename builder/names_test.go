@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOutputNames(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  map[string]string
+	}{
+		{[]string{"img.jpg"}, map[string]string{"img.jpg": "img.jpg"}},
+		{[]string{"day1/img.jpg", "day1/sub/img.jpg"}, map[string]string{"day1/img.jpg": "day1_img.jpg", "day1/sub/img.jpg": "day1_sub_img.jpg"}},
+		{[]string{"/photos/2024/img.jpg"}, map[string]string{"/photos/2024/img.jpg": "photos_2024_img.jpg"}},
+		{[]string{"../other/img.jpg"}, map[string]string{"../other/img.jpg": ".._other_img.jpg"}},
+		{[]string{"my photo (1).jpg", "a&b#c?.jpg"}, map[string]string{"my photo (1).jpg": "my_photo__1_.jpg", "a&b#c?.jpg": "a_b_c_.jpg"}},
+		{[]string{"café-Ω_1.jpg"}, map[string]string{"café-Ω_1.jpg": "café-Ω_1.jpg"}},
+		{[]string{"img.jpg", "img.jpg.xmp"}, map[string]string{"img.jpg": "img.jpg", "img.jpg.xmp": "img.jpg.xmp"}},
+	}
+	for _, tc := range tests {
+		if got := outputNames(tc.files); !maps.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.files, got, tc.want)
+		}
+	}
+}
+
+// hashedName matches an output name with a hash appended.
+var hashedName = regexp.MustCompile(`^(.*)-[0-9a-f]{8}(\.[a-z]+)$`)
+
+// TestOutputNamesCollide checks that colliding names are made unique, independent of the order of the files,
+// with the first of the colliding files (in the order of the source paths) keeping its name.
+func TestOutputNamesCollide(t *testing.T) {
+	tests := []struct {
+		files []string
+		stems []string // Stem of the name of each file before the hash, or empty if not hashed
+	}{
+		{[]string{"a/x.jpg", "a_x.jpg"}, []string{"", "a_x"}},
+		{[]string{"IMG_1.jpg", "img_1.png", "IMG_1.heic"}, []string{"IMG_1", "img_1", ""}},
+		{[]string{"a b.jpg", "a_b.jpg", "c.jpg"}, []string{"", "a_b", ""}},
+	}
+	for _, tc := range tests {
+		names := outputNames(tc.files)
+		rev := slices.Clone(tc.files)
+		slices.Reverse(rev)
+		if rn := outputNames(rev); !maps.Equal(names, rn) {
+			t.Errorf("%q: names depend on the order: %q and %q", tc.files, names, rn)
+		}
+		seen := make(map[string]bool)
+		for i, f := range tc.files {
+			n := names[f]
+			if seen[strings.ToLower(n)] {
+				t.Errorf("%q: duplicate name %s", tc.files, n)
+			}
+			seen[strings.ToLower(n)] = true
+			m := hashedName.FindStringSubmatch(n)
+			switch {
+			case tc.stems[i] == "":
+				if n != safeName(flatten(f)) {
+					t.Errorf("%q: %s: got %s, want %s", tc.files, f, n, safeName(flatten(f)))
+				}
+			case m == nil || m[1] != tc.stems[i] || !strings.HasSuffix(f, m[2]):
+				t.Errorf("%q: %s: got %s, want %s-<hash>", tc.files, f, n, tc.stems[i])
+			}
+		}
+	}
+}
+
+// TestOutputNamesAdded checks that published images keep their names when files
+// with colliding names that sort after them are added.
+func TestOutputNamesAdded(t *testing.T) {
+	tests := []struct {
+		files, added []string
+	}{
+		{[]string{"a/x.jpg"}, []string{"a_x.jpg"}},
+		{[]string{"IMG_1.jpg", "b.jpg"}, []string{"img_1.png", "IMG_1.tif"}},
+		{[]string{"day1/a b.jpg"}, []string{"day1/a_b.jpg", "day1_a/b.jpg"}},
+	}
+	for _, tc := range tests {
+		before := outputNames(tc.files)
+		after := outputNames(append(slices.Clone(tc.added), tc.files...))
+		for _, f := range tc.files {
+			if after[f] != before[f] {
+				t.Errorf("%q: adding %q renamed %s from %s to %s", tc.files, tc.added, f, before[f], after[f])
+			}
+		}
+		for _, f := range tc.added {
+			if !hashedName.MatchString(after[f]) {
+				t.Errorf("%q: added %s: got %s, want a hashed name", tc.files, f, after[f])
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aamcrae/pweb/imager/raw"
+	"github.com/aamcrae/pweb/shared"
+)
+
+// Raw file pairing, where a raw file with the same base name as an image
+// (e.g IMG_1234.CR3 and IMG_1234.jpg) is offered as an additional download.
+const (
+	PAIR_NONE     = iota // Raw files are not paired
+	PAIR_DOWNLOAD        // Offer the paired files as downloads
+	PAIR_ZIP             // Also add the paired files to the zip file
+)
+
+// pairExtensions lists the extensions of the raw files that are paired with images,
+// including raw formats that cannot be read by pweb.
+var pairExtensions = append(slices.Clone(raw.Extensions), ".cr3", ".crw", ".raf", ".orf", ".rw2", ".3fr", ".iiq")
+
+// extra is an additional file that is offered as a download with a picture.
+type extra struct {
+	srcPath string // Full pathname of the file
+	dlFile  string // Download filename relative to destDir
+}
+
+// isPairFile returns true if the file is a raw file that may be paired with an image.
+func isPairFile(f string) bool {
+	return slices.Contains(pairExtensions, strings.ToLower(path.Ext(f)))
+}
+
+// stem returns the filename without the extension.
+func stem(f string) string {
+	return strings.TrimSuffix(f, path.Ext(f))
+}
+
+// removePaired removes the raw files from the list that are paired with
+// another selected image, so that only the image is published.
+func (b *build) removePaired(files []string) []string {
+	images := make(map[string]struct{})
+	for _, f := range files {
+		if !isPairFile(f) {
+			images[strings.ToLower(stem(f))] = struct{}{}
+		}
+	}
+	var out []string
+	for _, f := range files {
+		if _, ok := images[strings.ToLower(stem(f))]; ok && isPairFile(f) {
+			b.verbosef("%s: paired with an image, not published\n", f)
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// findPaired returns the raw files in the same directory with the same base name
+// as the image, and the sidecar files of the raw files. The download filenames
+// use the output name of the image in place of the base name.
+func (b *build) findPaired(srcPath, outStem string) ([]extra, error) {
+	dir, name := path.Split(srcPath)
+	names, ok := b.dirs[dir]
+	if !ok {
+		dentries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dentries {
+			if d.Type().IsRegular() {
+				names = append(names, d.Name())
+			}
+		}
+		b.dirs[dir] = names
+	}
+	var extras []extra
+	// The files start with the base name of the raw file.
+	add := func(f string, baseLen int) {
+		if !slices.ContainsFunc(extras, func(e extra) bool { return e.srcPath == f }) {
+			dl := outStem + safeName(path.Base(f)[baseLen:])
+			extras = append(extras, extra{srcPath: f, dlFile: path.Join(shared.DownloadDir, dl)})
+		}
+	}
+	for _, n := range names {
+		if n != name && isPairFile(n) && strings.EqualFold(stem(n), stem(name)) {
+			rawPath := path.Join(dir, n)
+			add(rawPath, len(stem(n)))
+			if sc := findSidecar(rawPath); sc != "" {
+				add(sc, len(stem(n)))
+			}
+		}
+	}
+	return extras, nil
+}
@@ -0,0 +1,234 @@
+package builder
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Columns of the manifest file.
+var photoListColumns = []string{"filename", "title", "caption", "rating", "order", "exclude"}
+
+// Informational columns written by ExportManifest, holding the title, caption and rating from the
+// image metadata. These are not manifest columns, so they are ignored when the manifest is read.
+var metadataColumns = []string{"metadata-title", "metadata-caption", "metadata-rating"}
+
+// photoEntry holds the settings of a photo from the manifest file.
+type photoEntry struct {
+	title    string
+	caption  string
+	rating   string // If set, overrides the rating in the metadata
+	order    int
+	hasOrder bool
+	exclude  bool
+}
+
+// photoList is the manifest file, a CSV or TSV file of the photo settings keyed by filename.
+type photoList map[string]*photoEntry
+
+// readPhotoList reads the manifest file. The first row holds the column names, which may
+// be in any order; only the filename column is required, and other columns are ignored.
+// The file is read as TSV if the first row contains a tab, otherwise as CSV.
+func readPhotoList(file string) (photoList, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, &ConfigError{Keyword: "manifest", Err: err}
+	}
+	r := csv.NewReader(strings.NewReader(string(b)))
+	if first, _, _ := strings.Cut(string(b), "\n"); strings.Contains(first, "\t") {
+		r.Comma = '\t'
+		r.LazyQuotes = true
+	}
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	perr := func(line int, err error) error {
+		return &ConfigError{File: file, Line: line, Keyword: "manifest", Err: err}
+	}
+	header, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("missing column names")
+		}
+		return nil, perr(0, err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if slices.Contains(photoListColumns, h) {
+			cols[h] = i
+		}
+	}
+	if _, ok := cols["filename"]; !ok {
+		return nil, perr(1, errors.New("no filename column"))
+	}
+	pl := make(photoList)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return pl, nil
+		}
+		if err != nil {
+			return nil, perr(0, err)
+		}
+		line, _ := r.FieldPos(0)
+		get := func(col string) string {
+			if i, ok := cols[col]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		name := get("filename")
+		if name == "" {
+			continue
+		}
+		if _, ok := pl[name]; ok {
+			return nil, perr(line, fmt.Errorf("duplicate filename (%s)", name))
+		}
+		e := &photoEntry{title: get("title"), caption: get("caption"), rating: get("rating")}
+		if e.rating != "" {
+			if _, ok := rScaleMap[e.rating]; !ok {
+				return nil, perr(line, fmt.Errorf("bad rating (%s)", e.rating))
+			}
+		}
+		if o := get("order"); o != "" {
+			if e.order, err = strconv.Atoi(o); err != nil {
+				return nil, perr(line, fmt.Errorf("bad order (%s)", o))
+			}
+			e.hasOrder = true
+		}
+		switch x := strings.ToLower(get("exclude")); x {
+		case "", "0", "n", "no", "false":
+		case "1", "y", "yes", "true", "x":
+			e.exclude = true
+		default:
+			return nil, perr(line, fmt.Errorf("bad exclude flag (%s)", x))
+		}
+		pl[name] = e
+	}
+}
+
+// hasTitles returns true if the list contains any titles or captions.
+func (pl photoList) hasTitles() bool {
+	for _, e := range pl {
+		if e.title != "" || e.caption != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply removes the excluded files from the list, and orders the files. The files
+// with an order are placed first in order, followed by the remaining files in their existing order.
+func (pl photoList) apply(b *build, files []string) []string {
+	var out []string
+	for _, f := range files {
+		if e, ok := pl[f]; ok && e.exclude {
+			b.verbosef("%s: Skipping due to manifest exclude\n", f)
+			continue
+		}
+		out = append(out, f)
+	}
+	for f, e := range pl {
+		if !e.exclude && !slices.Contains(files, f) {
+			b.warnf("Cannot find manifest entry %s in file list, ignored", f)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b string) int {
+		ea, eb := pl[a], pl[b]
+		oa, ob := ea != nil && ea.hasOrder, eb != nil && eb.hasOrder
+		switch {
+		case oa && ob:
+			return cmp.Compare(ea.order, eb.order)
+		case oa:
+			return -1
+		case ob:
+			return 1
+		}
+		return 0
+	})
+	return out
+}
+
+// setRatings sets the ratings of the pictures that have a rating in the list.
+func (pl photoList) setRatings(picts []*Pict) {
+	for _, p := range picts {
+		if e, ok := pl[p.srcFile]; ok && e.rating != "" && p.exif != nil {
+			p.exif.rating = e.rating
+		}
+	}
+}
+
+// ExportManifest writes a manifest file of all the pictures that are candidates for the gallery,
+// including those excluded by the manifest file or by the filters. The selected pictures are
+// written first in the final order, followed by the other pictures, which are marked as excluded
+// if they are excluded by the manifest file. The title, caption and rating columns only hold the
+// values set in the manifest file, so that re-importing the manifest does not fix the values read from
+// the image metadata (or the titles generated from templates). The metadata values are written to
+// the informational metadata columns. The manifest is written as TSV if tsv is set, otherwise as CSV.
+func ExportManifest(ctx context.Context, conf *GalleryConfig, opts Options, w io.Writer, tsv bool) error {
+	b, err := newBuild(ctx, conf, opts)
+	if err != nil {
+		return err
+	}
+	files, err := b.selectFiles()
+	if err != nil {
+		return err
+	}
+	all, err := b.readPicts(files, true)
+	if err != nil {
+		return err
+	}
+	// Record the manifest settings and the metadata values before the ratings are replaced
+	// and the titles are set from the caption sources.
+	values := make(map[*Pict][]string)
+	byFile := make(map[string]*Pict)
+	for _, p := range all {
+		var t, c, r string
+		if e, ok := b.photos[p.srcFile]; ok {
+			t, c, r = e.title, e.caption, e.rating
+		}
+		values[p] = []string{t, c, r, p.exif.title, p.exif.caption, p.exif.rating}
+		byFile[p.srcFile] = p
+	}
+	var picts []*Pict
+	if b.photos != nil {
+		files = b.photos.apply(b, files)
+	}
+	for _, f := range files {
+		if p, ok := byFile[f]; ok {
+			picts = append(picts, p)
+		}
+	}
+	picts = b.orderPicts(picts, true)
+	cw := csv.NewWriter(w)
+	if tsv {
+		cw.Comma = '\t'
+	}
+	cw.Write(append(slices.Clone(photoListColumns), metadataColumns...))
+	row := func(p *Pict, order, exclude string) []string {
+		v := values[p]
+		return []string{p.srcFile, v[0], v[1], v[2], order, exclude, v[3], v[4], v[5]}
+	}
+	for i, p := range picts {
+		cw.Write(row(p, strconv.Itoa(i+1), ""))
+	}
+	for _, p := range all {
+		if slices.Contains(picts, p) {
+			continue
+		}
+		var exclude string
+		if e, ok := b.photos[p.srcFile]; ok && e.exclude {
+			exclude = "x"
+		}
+		cw.Write(row(p, "", exclude))
+	}
+	cw.Flush()
+	return cw.Error()
+}
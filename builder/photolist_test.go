@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPhotoList(t *testing.T) {
+	tests := []struct {
+		text string
+		want photoList
+		line int // Line of the error
+		err  string
+	}{
+		{
+			text: "filename,title,caption,rating,order,exclude\na.jpg,Title,\"Caption, with comma\",3,2,\nb.jpg,,,,,x\n",
+			want: photoList{
+				"a.jpg": {title: "Title", caption: "Caption, with comma", rating: "3", order: 2, hasOrder: true},
+				"b.jpg": {exclude: true},
+			},
+		},
+		{
+			// Columns in any order and case, with unknown columns and comments ignored.
+			text: "# Exported\nOrder, Notes ,FILENAME\n1,note,c.jpg\n# comment\n, , d.jpg \n",
+			want: photoList{
+				"c.jpg": {order: 1, hasOrder: true},
+				"d.jpg": {},
+			},
+		},
+		{
+			// TSV, with quotes in the text.
+			text: "filename\ttitle\texclude\ne.jpg\tSay \"cheese\"\tno\n\t\t\n",
+			want: photoList{
+				"e.jpg": {title: `Say "cheese"`},
+			},
+		},
+		{text: "filename\na.jpg\n", want: photoList{"a.jpg": {}}},
+		{text: "", err: "missing column names"},
+		{text: "title,rating\nA,1\n", line: 1, err: "no filename column"},
+		{text: "filename,rating\na.jpg,1\nb.jpg,6\n", line: 3, err: "bad rating (6)"},
+		{text: "filename,order\na.jpg,first\n", line: 2, err: "bad order (first)"},
+		{text: "filename,exclude\na.jpg,maybe\n", line: 2, err: "bad exclude flag (maybe)"},
+		{text: "filename\na.jpg\n\na.jpg\n", line: 4, err: "duplicate filename (a.jpg)"},
+	}
+	for _, tc := range tests {
+		f := filepath.Join(t.TempDir(), "photos.csv")
+		if err := os.WriteFile(f, []byte(tc.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pl, err := readPhotoList(f)
+		if tc.err != "" {
+			var ce *ConfigError
+			if !errors.As(err, &ce) || ce.Line != tc.line || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: got error %v, want line %d, %s", tc.text, err, tc.line, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(pl, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.text, pl, tc.want)
+		}
+	}
+}
+
+func TestPhotoListApply(t *testing.T) {
+	pl := photoList{
+		"b.jpg": {order: 2, hasOrder: true},
+		"c.jpg": {exclude: true},
+		"d.jpg": {order: 1, hasOrder: true},
+		"x.jpg": {title: "Missing"},
+	}
+	b := &build{}
+	got := pl.apply(b, []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"})
+	want := []string{"d.jpg", "b.jpg", "a.jpg", "e.jpg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestExportManifest checks that the exported manifest only holds the manifest settings in the
+// title, caption and rating columns, with the metadata values in the informational columns.
+func TestExportManifest(t *testing.T) {
+	dir := t.TempDir()
+	xmp := func(title, rating string) string {
+		return `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="` + rating + `">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + title + `</rdf:li></rdf:Alt></dc:title>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	}
+	files := map[string]string{
+		"a.jpg":        "\xFF\xD8\xFF\xD9",
+		"a.jpg.xmp":    xmp("Summit", "4"),
+		"b.jpg":        "\xFF\xD8\xFF\xD9",
+		"b.jpg.xmp":    xmp("Beach", "2"),
+		"c.jpg":        "\xFF\xD8\xFF\xD9",
+		"manifest.csv": "filename,title,rating,exclude\nb.jpg,Tawau beach,5,\nc.jpg,,,x\n",
+		"web":          "dir: out\nmanifest: manifest.csv\n",
+	}
+	for f, s := range files {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf, err := LoadConfig(filepath.Join(dir, "web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.SrcDir = dir
+	md, err := SelectMetadata("goexif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := ExportManifest(t.Context(), conf, Options{BaseDir: t.TempDir(), Metadata: md}, &out, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "filename,title,caption,rating,order,exclude,metadata-title,metadata-caption,metadata-rating\n" +
+		"a.jpg,,,,1,,Summit,,4\n" +
+		"b.jpg,Tawau beach,,5,2,,Beach,,2\n" +
+		"c.jpg,,,,,x,,,\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+	// Reading the exported manifest gives the same settings as the original.
+	f := filepath.Join(dir, "export.csv")
+	if err := os.WriteFile(f, []byte(out.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pl, err := readPhotoList(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantList := photoList{
+		"a.jpg": {order: 1, hasOrder: true},
+		"b.jpg": {title: "Tawau beach", rating: "5", order: 2, hasOrder: true},
+		"c.jpg": {exclude: true},
+	}
+	if !reflect.DeepEqual(pl, wantList) {
+		t.Errorf("re-read manifest: got %v, want %v", pl, wantList)
+	}
+}
@@ -0,0 +1,301 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/aamcrae/pweb/imager"
+	"github.com/aamcrae/pweb/shared"
+)
+
+type Pict struct {
+	b        *build
+	srcFile  string  // Source filename, relative to the source directory
+	srcPath  string  // Full pathname of source file
+	destDir  string  // Destination directory for web page
+	dlFile   string  // Download filename relative to destDir
+	destFile string  // Image filename relative to destDir and rendition directories
+	baseName string  // Base filename
+	sidecar  string  // Full pathname of XMP sidecar file, if any
+	extras   []extra // Paired files offered as additional downloads
+
+	mtime         time.Time // File modified time
+	size          int64     // File size
+	sidecarMtime  time.Time // Sidecar modified time
+	exif          *Exif     // Lazily loaded Exif data
+	width, height int
+}
+
+// newPict creates a picture from the source file. The gallery images and download
+// are written using the name (see outputNames).
+func newPict(b *build, fname, name string) (*Pict, error) {
+	srcPath := fname
+	if !path.IsAbs(fname) {
+		srcPath = path.Join(b.srcDir, fname)
+	}
+	st, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	dlFile := path.Join(shared.DownloadDir, name)
+	destFile := name
+	if !imager.IsWebImage(name) {
+		// The scaled images are written as JPEG, and the original is offered as the download.
+		destFile = stem(name) + ".jpg"
+	}
+	var extras []extra
+	if b.conf.Pair != PAIR_NONE {
+		if extras, err = b.findPaired(srcPath, stem(name)); err != nil {
+			return nil, err
+		}
+	}
+	var sidecar string
+	var sidecarMtime time.Time
+	if b.conf.Sidecar != SIDECAR_IGNORE {
+		if sidecar = findSidecar(srcPath); sidecar != "" {
+			if sidecarMtime, err = getMtime(sidecar); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Pict{
+		b:            b,
+		srcFile:      fname,
+		srcPath:      srcPath,
+		destDir:      b.destDir,
+		dlFile:       dlFile,
+		destFile:     destFile,
+		mtime:        st.ModTime(),
+		size:         st.Size(),
+		baseName:     path.Base(fname),
+		sidecar:      sidecar,
+		sidecarMtime: sidecarMtime,
+		extras:       extras,
+	}, nil
+}
+
+// GetExif returns the EXIF data for the picture, loading it from the
+// cache or the file if it is not already loaded. The EXIF data is only set
+// once it is complete (a picture whose read has timed out is skipped, but the read may still finish).
+func (p *Pict) GetExif() (*Exif, error) {
+	if p.exif == nil {
+		b := p.b
+		exif, ok := b.opts.Cache.lookup(p, b.reader)
+		if !ok {
+			var err error
+			if exif, err = readExif(b.opts.Metadata.Open, p.srcPath, p.sidecar, b.conf.Sidecar, b.fields); err != nil {
+				return nil, &ImageError{File: p.srcFile, Op: "exif read", Err: err}
+			}
+			b.opts.Cache.add(p, b.reader, exif)
+			b.verbosef("%s: exif: %v\n", p.srcFile, *exif)
+		}
+		if exif.ts.IsZero() {
+			// Use file timestamp
+			exif.ts = p.mtime
+		}
+		p.exif = exif
+	}
+	return p.exif, nil
+}
+
+// AddGallery adds this picture to the gallery structure.
+func (p *Pict) AddToGallery(g *shared.Gallery, download int) error {
+	var ph shared.Photo
+	exif, err := p.GetExif()
+	if err != nil {
+		return err
+	}
+	ph.Name = p.baseName
+	ph.Filename = p.destFile
+	ph.Date = exif.ts.Format("03:04 PM Monday, 02 January 2006")
+	ph.Original.Width = p.width
+	ph.Original.Height = p.height
+	if p.b.conf.NoCaption != CAPTION_HIDE {
+		ph.Title = exif.title
+		ph.Caption = exif.caption
+	}
+	ph.Exposure = exif.exposure
+	ph.ISO = exif.iso
+	ph.Aperture = exif.fstop
+	ph.FocalLength = exif.focal_len
+	if download != DL_NONE {
+		ph.Download = p.dlFile
+		for _, e := range p.extras {
+			ph.Extras = append(ph.Extras, e.dlFile)
+		}
+	}
+	g.Photos = append(g.Photos, ph)
+	return nil
+}
+
+// Resize resizes this picture to each of the renditions (e.g the web page size,
+// the preview and the thumbnail) using the selected image processor.
+// The build manifest is used to determine which of the renditions need to be rebuilt, either
+// because the source has changed, or the rendering parameters have changed.
+// If the context is cancelled, no further images are written, and any partially written image is removed.
+// The number of images written is returned.
+func (p *Pict) Resize(ctx context.Context, renditions []rendition, m *buildManifest) (int, error) {
+	exif, err := p.GetExif()
+	if err != nil {
+		return 0, err
+	}
+	stale, current := p.checkRenditions(exif, renditions, m)
+	for file, e := range current {
+		m.add(file, e)
+	}
+	if len(stale) == 0 && p.width > 0 && p.height > 0 {
+		p.b.verbosef("Skipping read/decode of %s\n", p.destFile)
+		return 0, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	img, method, err := p.open(exif, stale)
+	if err != nil {
+		return 0, &ImageError{File: p.srcFile, Op: "read", Err: err}
+	}
+	defer imager.Close(img)
+	if len(stale) == 0 {
+		p.b.verbosef("Skipping resize of %s\n", p.destFile)
+		return 0, nil
+	}
+	p.b.verbosef("Resizing %s from %d x %d using %s (%d of %d images)\n", p.srcFile, img.Width(), img.Height(), method, len(stale), len(renditions))
+	if err := orient(img, exif.orientation); err != nil {
+		return 0, &ImageError{File: p.srcFile, Op: "orient", Err: err}
+	}
+	// Renditions of the same size share a scaled image, and each size is
+	// scaled from a larger one where possible.
+	var sizes []imager.Size
+	for _, r := range stale {
+		if sz := (imager.Size{Width: r.width, Height: r.height}); !slices.Contains(sizes, sz) {
+			sizes = append(sizes, sz)
+		}
+	}
+	err = imager.Cascade(img, sizes, func(i int, scaled imager.Image) error {
+		for _, r := range stale {
+			if r.width != sizes[i].Width || r.height != sizes[i].Height {
+				continue
+			}
+			file := path.Join(r.dir, r.filename(p.destFile))
+			e := m.entry(p, r, exif.orientation, method != fullDecode)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := writeImage(ctx, scaled, path.Join(p.destDir, file), e.Mtime, r); err != nil {
+				return &ImageError{File: p.srcFile, Op: "write " + file, Err: err}
+			}
+			e.Width, e.Height = p.width, p.height
+			m.add(file, e)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(*ImageError); !ok && ctx.Err() == nil {
+			err = &ImageError{File: p.srcFile, Op: "resize", Err: err}
+		}
+		return 0, err
+	}
+	return len(stale), nil
+}
+
+// fullDecode is the method returned by open when the full image is decoded.
+const fullDecode = "full decode"
+
+// open reads the image to be resized, returning the method used to read it.
+// If fast thumbnails are enabled and the full size of the image is known, the image
+// is read at a reduced resolution if that is sufficient for all the renditions being
+// rebuilt, otherwise the full image is decoded.
+func (p *Pict) open(exif *Exif, stale []rendition) (imager.Image, string, error) {
+	im := p.b.opts.Imager
+	if p.b.opts.FastThumbs && im.OpenReduced != nil && p.width > 0 && p.height > 0 && len(stale) > 0 {
+		// Find the largest image that is needed.
+		var w, h int
+		for _, r := range stale {
+			fw, fh := imager.Fit(p.width, p.height, imager.Size{Width: r.width, Height: r.height})
+			w, h = max(w, fw), max(h, fh)
+		}
+		if w < p.width && h < p.height {
+			// The image is read before it is oriented.
+			fullW, fullH := displaySize(exif.orientation, p.width, p.height)
+			minW, minH := displaySize(exif.orientation, w, h)
+			full := imager.Size{Width: fullW, Height: fullH}
+			img, method, err := im.OpenReduced(p.srcPath, full, imager.Size{Width: minW, Height: minH})
+			if err == nil {
+				return img, method, nil
+			}
+			p.b.verbosef("%s: cannot read at reduced resolution: %v\n", p.srcFile, err)
+		}
+	}
+	img, err := im.Open(p.srcPath)
+	if err != nil {
+		return nil, "", err
+	}
+	p.width, p.height = displaySize(exif.orientation, img.Width(), img.Height())
+	return img, fullDecode, nil
+}
+
+// checkRenditions checks which renditions are out of date, returning the renditions
+// to be rebuilt, and the manifest entries of the renditions that are current.
+// The original resolution is set from the EXIF data, or from the manifest if it is
+// not in the EXIF data. A rendition scaled from a reduced resolution image is
+// only current if fast thumbnails are enabled.
+func (p *Pict) checkRenditions(exif *Exif, renditions []rendition, m *buildManifest) ([]rendition, map[string]manifestEntry) {
+	// The original resolution is recorded as displayed i.e after any rotation.
+	if exif.width != 0 && exif.height != 0 {
+		p.width, p.height = displaySize(exif.orientation, exif.width, exif.height)
+	}
+	var stale []rendition
+	current := make(map[string]manifestEntry)
+	for _, r := range renditions {
+		file := path.Join(r.dir, r.filename(p.destFile))
+		old, ok := m.current(file, m.entry(p, r, exif.orientation, false))
+		if !ok && p.b.opts.FastThumbs {
+			old, ok = m.current(file, m.entry(p, r, exif.orientation, true))
+		}
+		if ok {
+			if p.width == 0 || p.height == 0 {
+				p.width, p.height = old.Width, old.Height
+			}
+			current[file] = old
+		} else {
+			stale = append(stale, r)
+		}
+	}
+	return stale, current
+}
+
+// memoryCost estimates the memory required to resize the picture, or returns 0
+// if the image does not need to be decoded.
+func (p *Pict) memoryCost(renditions []rendition, m *buildManifest) int64 {
+	exif, err := p.GetExif()
+	if err != nil {
+		// The error is reported when the picture is resized.
+		return 0
+	}
+	if stale, _ := p.checkRenditions(exif, renditions, m); len(stale) == 0 && p.width > 0 && p.height > 0 {
+		return 0
+	}
+	return p.imageMemory(exif)
+}
+
+// writeImage writes the rendition of the image to a temporary file, which is
+// renamed to the destination file if the context has not been cancelled.
+func writeImage(ctx context.Context, img imager.Image, dest string, mtime time.Time, r rendition) error {
+	dir, name := path.Split(dest)
+	tmp := path.Join(dir, tmpPrefix+name)
+	if err := img.Write(tmp, mtime, r.quality, r.format); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aamcrae/pweb/exif"
+	"github.com/aamcrae/pweb/exif/xmp"
+)
+
+// Sidecar modes, selecting how XMP sidecar files are used.
+const (
+	SIDECAR_PREFER = iota // Sidecar metadata has precedence over embedded metadata
+	SIDECAR_IGNORE        // Sidecar files are not read
+	SIDECAR_ONLY          // Descriptive metadata is only read from the sidecar
+)
+
+// iptcToXmp maps the IPTC keys to the equivalent XMP properties,
+// since sidecar files only contain XMP.
+var iptcToXmp = map[string]string{
+	"Iptc.Application2.ObjectName":    "Xmp.dc.title",
+	"Iptc.Application2.Headline":      "Xmp.photoshop.Headline",
+	"Iptc.Application2.Caption":       "Xmp.dc.description",
+	"Iptc.Application2.Keywords":      "Xmp.dc.subject",
+	"Iptc.Application2.Byline":        "Xmp.dc.creator",
+	"Iptc.Application2.Copyright":     "Xmp.dc.rights",
+	"Iptc.Application2.City":          "Xmp.photoshop.City",
+	"Iptc.Application2.ProvinceState": "Xmp.photoshop.State",
+	"Iptc.Application2.CountryName":   "Xmp.photoshop.Country",
+	"Iptc.Application2.SubLocation":   "Xmp.iptc.Location",
+}
+
+// technical lists the XMP namespaces that describe the image data rather than
+// the content. These are always read from the image, since a sidecar may refer to the raw file.
+var technical = []string{"Xmp.tiff.", "Xmp.exif.", "Xmp.exifEX.", "Xmp.aux.", "Xmp.crs."}
+
+// findSidecar returns the name of the XMP sidecar file for the image,
+// checking for both <image>.xmp and <image-without-extension>.xmp.
+func findSidecar(fname string) string {
+	base := strings.TrimSuffix(fname, filepath.Ext(fname))
+	for _, s := range []string{fname + ".xmp", fname + ".XMP", base + ".xmp", base + ".XMP"} {
+		if st, err := os.Stat(s); err == nil && st.Mode().IsRegular() {
+			return s
+		}
+	}
+	return ""
+}
+
+// sidecarReader merges the metadata from an XMP sidecar file with
+// the metadata embedded in the image.
+// The descriptive metadata (IPTC and XMP, such as title, caption and rating) is
+// read from the sidecar first. If the sidecar mode is SIDECAR_ONLY, the embedded descriptive
+// metadata is ignored. The technical metadata (EXIF) is always read from the image.
+type sidecarReader struct {
+	image   exif.MetadataReader
+	sidecar *xmp.Xmp
+	only    bool
+}
+
+// newSidecarReader reads and parses the sidecar file. If there is
+// no sidecar file, an empty set of sidecar metadata is used.
+func newSidecarReader(image exif.MetadataReader, sidecar string, only bool) (*sidecarReader, error) {
+	s := &sidecarReader{image: image, sidecar: new(xmp.Xmp), only: only}
+	if sidecar != "" {
+		b, err := os.ReadFile(sidecar)
+		if err != nil {
+			return nil, err
+		}
+		if s.sidecar, err = xmp.Parse(b); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *sidecarReader) Get(keys ...string) string {
+	var embedded []string
+	for _, k := range keys {
+		if xk, ok := descriptive(k); ok {
+			if v := s.sidecar.Get(xk); v != "" {
+				return v
+			}
+			if s.only {
+				continue
+			}
+		}
+		embedded = append(embedded, k)
+	}
+	return s.image.Get(embedded...)
+}
+
+func (s *sidecarReader) GetList(keys ...string) []string {
+	var embedded []string
+	for _, k := range keys {
+		if xk, ok := descriptive(k); ok {
+			if v := s.sidecar.GetList(xk); len(v) > 0 {
+				return v
+			}
+			if s.only {
+				continue
+			}
+		}
+		embedded = append(embedded, k)
+	}
+	return s.image.GetList(embedded...)
+}
+
+// descriptive returns the XMP key in the sidecar that corresponds to this key,
+// or false if the key is technical metadata.
+func descriptive(k string) (string, bool) {
+	if strings.HasPrefix(k, "Iptc.") {
+		return iptcToXmp[k], true
+	}
+	if !strings.HasPrefix(k, "Xmp.") {
+		return "", false
+	}
+	for _, t := range technical {
+		if strings.HasPrefix(k, t) {
+			return "", false
+		}
+	}
+	return k, true
+}
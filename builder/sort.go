@@ -0,0 +1,190 @@
+package builder
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SortKey is one of the keys used to sort the photos.
+type SortKey struct {
+	Key  int    // SORT_NAME, SORT_DATE, SORT_NATURAL, SORT_RATING, SORT_TITLE or SORT_SHUFFLE
+	Desc bool   // Sort in descending order
+	Seed uint64 // Seed of the shuffle
+}
+
+// Names of the sort keys used in the config file.
+var sortNames = map[string]int{
+	"name":    SORT_NAME,
+	"date":    SORT_DATE,
+	"natural": SORT_NATURAL,
+	"rating":  SORT_RATING,
+	"title":   SORT_TITLE,
+	"shuffle": SORT_SHUFFLE,
+}
+
+// Metadata fields used to order photos taken in the same second.
+var sortFieldList = []metaField{
+	{"subsec", []string{"Exif.Photo.SubSecTimeOriginal", "Exif.Photo.SubSecTimeDigitized", "Exif.Photo.SubSecTime"}},
+	{"sequence", []string{"Exif.Image.ImageNumber", "Xmp.aux.ImageNumber"}},
+}
+
+// parseSort parses a list of comma separated sort keys, each of which is a key name
+// optionally followed by "asc" or "desc" e.g "rating desc, date". The shuffle key may
+// be followed by a seed, e.g "shuffle 42".
+func parseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, k := range strings.Split(s, ",") {
+		f := strings.Fields(k)
+		if len(f) == 0 {
+			return nil, fmt.Errorf("missing sort key (%s)", s)
+		}
+		key, ok := sortNames[f[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort key (%s)", f[0])
+		}
+		if slices.ContainsFunc(keys, func(sk SortKey) bool { return sk.Key == key }) {
+			return nil, fmt.Errorf("duplicate sort key (%s)", f[0])
+		}
+		sk := SortKey{Key: key}
+		if len(f) > 2 {
+			return nil, fmt.Errorf("too many arguments (%s)", strings.TrimSpace(k))
+		}
+		if len(f) == 2 {
+			var err error
+			switch {
+			case key == SORT_SHUFFLE:
+				if sk.Seed, err = strconv.ParseUint(f[1], 10, 64); err != nil {
+					return nil, fmt.Errorf("bad shuffle seed (%s)", f[1])
+				}
+			case f[1] == "desc":
+				sk.Desc = true
+			case f[1] != "asc":
+				return nil, fmt.Errorf("unknown sort direction (%s)", f[1])
+			}
+		}
+		keys = append(keys, sk)
+	}
+	return keys, nil
+}
+
+// sortExif returns true if the EXIF data is required by the sort keys.
+func sortExif(keys []SortKey) bool {
+	return slices.ContainsFunc(keys, func(sk SortKey) bool {
+		return sk.Key == SORT_DATE || sk.Key == SORT_RATING || sk.Key == SORT_TITLE
+	})
+}
+
+// sortFields returns the metadata fields required by the sort keys.
+func sortFields(keys []SortKey) []metaField {
+	if slices.ContainsFunc(keys, func(sk SortKey) bool { return sk.Key == SORT_DATE }) {
+		return sortFieldList
+	}
+	return nil
+}
+
+// comparePicts compares the pictures using the sort keys, in order of precedence.
+func comparePicts(keys []SortKey, a, b *Pict) int {
+	for _, sk := range keys {
+		var c int
+		switch sk.Key {
+		case SORT_NAME:
+			c = strings.Compare(a.baseName, b.baseName)
+		case SORT_DATE:
+			c = compareDate(a, b)
+		case SORT_NATURAL:
+			c = naturalCompare(a.baseName, b.baseName)
+		case SORT_RATING:
+			c = cmp.Compare(a.ratingValue(), b.ratingValue())
+		case SORT_TITLE:
+			c = naturalCompare(strings.ToLower(a.exif.title), strings.ToLower(b.exif.title))
+		case SORT_SHUFFLE:
+			c = cmp.Compare(shuffleRank(sk.Seed, a.srcFile), shuffleRank(sk.Seed, b.srcFile))
+		}
+		if sk.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareDate compares the capture times of the pictures. Photos taken in the same
+// second (e.g in a burst) are ordered using the sub-second time, then the sequence number,
+// and then the filename.
+func compareDate(a, b *Pict) int {
+	if c := a.exif.ts.Compare(b.exif.ts); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(subSec(a.exif.fields["subsec"]), subSec(b.exif.fields["subsec"])); c != 0 {
+		return c
+	}
+	sa, _ := strconv.Atoi(strings.TrimSpace(a.exif.fields["sequence"]))
+	sb, _ := strconv.Atoi(strings.TrimSpace(b.exif.fields["sequence"]))
+	if c := cmp.Compare(sa, sb); c != 0 {
+		return c
+	}
+	return naturalCompare(a.baseName, b.baseName)
+}
+
+// subSec converts the EXIF sub-second time (the digits of a decimal fraction) to a fraction of a second.
+func subSec(s string) float64 {
+	v, _ := strconv.ParseFloat("0."+strings.TrimSpace(s), 64)
+	return v
+}
+
+// ratingValue returns the rating of the picture, with unrated pictures as 0.
+func (p *Pict) ratingValue() int {
+	r, _ := strconv.Atoi(p.exif.rating)
+	return r
+}
+
+// shuffleRank returns the position of the file in the shuffled order. The rank
+// depends only on the seed and the filename, so the order is repeatable, and adding
+// or removing photos does not change the order of the other photos.
+func shuffleRank(seed uint64, file string) uint64 {
+	h := fnv.New64a()
+	h.Write(strconv.AppendUint(nil, seed, 10))
+	h.Write([]byte{0})
+	h.Write([]byte(file))
+	return h.Sum64()
+}
+
+// naturalCompare compares the strings, treating runs of digits as numbers,
+// so that e.g "IMG_2" sorts before "IMG_10".
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digits(a), digits(b)
+		if da > 0 && db > 0 {
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			// Compare the numbers by length, then by value.
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[da:], b[db:]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// digits returns the number of leading digits in the string.
+func digits(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,152 @@
+package builder
+
+import (
+	"cmp"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []SortKey
+		err  string
+	}{
+		{in: "name", want: []SortKey{{Key: SORT_NAME}}},
+		{in: "rating desc, date", want: []SortKey{{Key: SORT_RATING, Desc: true}, {Key: SORT_DATE}}},
+		{in: " natural asc ,title desc", want: []SortKey{{Key: SORT_NATURAL}, {Key: SORT_TITLE, Desc: true}}},
+		{in: "shuffle", want: []SortKey{{Key: SORT_SHUFFLE}}},
+		{in: "rating desc, shuffle 42", want: []SortKey{{Key: SORT_RATING, Desc: true}, {Key: SORT_SHUFFLE, Seed: 42}}},
+		{in: "date,", err: "missing sort key"},
+		{in: "size", err: "unknown sort key (size)"},
+		{in: "date, name, date desc", err: "duplicate sort key (date)"},
+		{in: "date up", err: "unknown sort direction (up)"},
+		{in: "date desc now", err: "too many arguments (date desc now)"},
+		{in: "shuffle seed", err: "bad shuffle seed (seed)"},
+		{in: "shuffle -1", err: "bad shuffle seed (-1)"},
+	}
+	for _, tc := range tests {
+		got, err := parseSort(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: got error %v, want %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"IMG_2.jpg", "IMG_10.jpg", -1},
+		{"IMG_10.jpg", "IMG_2.jpg", 1},
+		{"IMG_002.jpg", "IMG_2.jpg", 0},
+		{"IMG_0010.jpg", "IMG_9.jpg", 1},
+		{"a1b2", "a1b10", -1},
+		{"a", "b", -1},
+		{"abc", "ab", 1},
+		{"", "a", -1},
+		{"12345678901234567890", "12345678901234567891", -1},
+		{"img1", "IMG1", 1},
+		{"2024-03-10", "2024-3-9", 1},
+	}
+	for _, tc := range tests {
+		if got := naturalCompare(tc.a, tc.b); got != tc.want {
+			t.Errorf("naturalCompare(%q, %q): got %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestComparePicts(t *testing.T) {
+	ts := time.Date(2024, 3, 10, 6, 30, 0, 0, time.UTC)
+	pict := func(name, rating, title string, ts time.Time, subsec, seq string) *Pict {
+		return &Pict{srcFile: name, baseName: name, exif: &Exif{rating: rating, title: title, ts: ts,
+			fields: map[string]string{"subsec": subsec, "sequence": seq}}}
+	}
+	picts := []*Pict{
+		pict("IMG_10.jpg", "3", "beach", ts, "5", ""),
+		pict("IMG_2.jpg", "", "Summit 10", ts.Add(time.Hour), "", ""),
+		pict("IMG_9.jpg", "5", "summit 9", ts, "45", "2"),
+		pict("IMG_1.jpg", "3", "", ts, "45", "1"),
+		pict("IMG_3.jpg", "5", "Beach", ts.Add(-time.Minute), "", ""),
+	}
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"name", []string{"IMG_1.jpg", "IMG_10.jpg", "IMG_2.jpg", "IMG_3.jpg", "IMG_9.jpg"}},
+		{"natural", []string{"IMG_1.jpg", "IMG_2.jpg", "IMG_3.jpg", "IMG_9.jpg", "IMG_10.jpg"}},
+		{"natural desc", []string{"IMG_10.jpg", "IMG_9.jpg", "IMG_3.jpg", "IMG_2.jpg", "IMG_1.jpg"}},
+		// Photos taken in the same second are ordered by the sub-second time (0.45 before 0.5), then the sequence number.
+		{"date", []string{"IMG_3.jpg", "IMG_1.jpg", "IMG_9.jpg", "IMG_10.jpg", "IMG_2.jpg"}},
+		{"date desc", []string{"IMG_2.jpg", "IMG_10.jpg", "IMG_9.jpg", "IMG_1.jpg", "IMG_3.jpg"}},
+		// Equal ratings keep their existing order.
+		{"rating", []string{"IMG_2.jpg", "IMG_10.jpg", "IMG_1.jpg", "IMG_9.jpg", "IMG_3.jpg"}},
+		{"rating desc, natural", []string{"IMG_3.jpg", "IMG_9.jpg", "IMG_1.jpg", "IMG_10.jpg", "IMG_2.jpg"}},
+		{"rating desc, date desc", []string{"IMG_9.jpg", "IMG_3.jpg", "IMG_10.jpg", "IMG_1.jpg", "IMG_2.jpg"}},
+		{"title, name", []string{"IMG_1.jpg", "IMG_10.jpg", "IMG_3.jpg", "IMG_9.jpg", "IMG_2.jpg"}},
+	}
+	for _, tc := range tests {
+		keys, err := parseSort(tc.sort)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.sort, err)
+		}
+		sorted := slices.Clone(picts)
+		slices.SortStableFunc(sorted, func(a, b *Pict) int {
+			return comparePicts(keys, a, b)
+		})
+		var got []string
+		for _, p := range sorted {
+			got = append(got, p.srcFile)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.sort, got, tc.want)
+		}
+	}
+}
+
+// TestShuffle checks that the shuffled order depends only on the seed, and that adding
+// a photo does not change the order of the other photos.
+func TestShuffle(t *testing.T) {
+	var files []string
+	for _, n := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		files = append(files, n+".jpg")
+	}
+	shuffle := func(seed uint64, files []string) []string {
+		s := slices.Clone(files)
+		slices.SortFunc(s, func(a, b string) int {
+			return cmp.Compare(shuffleRank(seed, a), shuffleRank(seed, b))
+		})
+		return s
+	}
+	s1 := shuffle(1, files)
+	if slices.Equal(s1, files) {
+		t.Errorf("seed 1: not shuffled: %q", s1)
+	}
+	rev := slices.Clone(files)
+	slices.Reverse(rev)
+	if s := shuffle(1, rev); !slices.Equal(s, s1) {
+		t.Errorf("seed 1: order depends on the input order: %q and %q", s1, s)
+	}
+	if s2 := shuffle(2, files); slices.Equal(s2, s1) {
+		t.Errorf("seeds 1 and 2 give the same order %q", s1)
+	}
+	added := shuffle(1, append(slices.Clone(files), "x.jpg"))
+	added = slices.DeleteFunc(added, func(f string) bool { return f == "x.jpg" })
+	if !slices.Equal(added, s1) {
+		t.Errorf("adding a photo changed the order: %q and %q", s1, added)
+	}
+}
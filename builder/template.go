@@ -0,0 +1,252 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default layout for dates in templates.
+const templateDateLayout = "2 January 2006"
+
+// metaField is a metadata value used by a template, read using the first key found.
+type metaField struct {
+	name string
+	keys []string
+}
+
+// Metadata fields that may be used in templates, in addition to the "exif." fields.
+var templateFields = map[string]metaField{
+	"headline":    {"headline", []string{"Iptc.Application2.Headline", "Xmp.photoshop.Headline"}},
+	"sublocation": {"sublocation", []string{"Iptc.Application2.SubLocation", "Xmp.iptc.Location"}},
+	"city":        {"city", []string{"Iptc.Application2.City", "Xmp.photoshop.City"}},
+	"state":       {"state", []string{"Iptc.Application2.ProvinceState", "Xmp.photoshop.State"}},
+	"country":     {"country", []string{"Iptc.Application2.CountryName", "Xmp.photoshop.Country"}},
+}
+
+// The fields joined to make the location.
+var locationFields = []string{"sublocation", "city", "state", "country"}
+
+// Variables of a picture, in addition to the metadata fields.
+var photoVars = []string{"date", "name", "title", "caption", "location"}
+
+// Variables of the gallery. The "first." and "last." prefixes select the variables
+// of the first or last picture in the gallery.
+var galleryVars = []string{"count", "date_range"}
+
+// template is a title or caption containing variables of the form {name} or {name:format},
+// where the format is a date layout for dates. "{{" and "}}" are a literal '{' and '}'.
+type template struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text or a variable.
+type templatePart struct {
+	text   string // Literal text, or the variable name
+	format string // Variable format, if any
+	isVar  bool
+}
+
+// parseTemplate parses the template. If gallery is set, the gallery variables
+// may be used, otherwise only the variables of a single picture.
+func parseTemplate(s string, gallery bool) (*template, error) {
+	t := &template{}
+	var text strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			text.WriteByte(s[i])
+			i++
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, errors.New("missing '}' in template")
+			}
+			name, format, _ := strings.Cut(s[i+1:i+end], ":")
+			if err := checkVar(name, gallery); err != nil {
+				return nil, err
+			}
+			if text.Len() > 0 {
+				t.parts = append(t.parts, templatePart{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, templatePart{text: name, format: format, isVar: true})
+			i += end
+		case s[i] == '}':
+			return nil, errors.New("unexpected '}' in template")
+		default:
+			text.WriteByte(s[i])
+		}
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: text.String()})
+	}
+	return t, nil
+}
+
+// checkVar checks that the variable name is known.
+func checkVar(name string, gallery bool) error {
+	v := name
+	if gallery {
+		if slices.Contains(galleryVars, name) {
+			return nil
+		}
+		var ok bool
+		if v, ok = strings.CutPrefix(name, "first."); !ok {
+			v, _ = strings.CutPrefix(name, "last.")
+		}
+	}
+	if slices.Contains(photoVars, v) {
+		return nil
+	}
+	if _, ok := templateFields[v]; ok {
+		return nil
+	}
+	if k, ok := strings.CutPrefix(v, "exif."); ok && k != "" {
+		return nil
+	}
+	return fmt.Errorf("unknown template variable (%s)", name)
+}
+
+// isTemplate returns true if the template contains any variables.
+func (t *template) isTemplate() bool {
+	return slices.ContainsFunc(t.parts, func(p templatePart) bool { return p.isVar })
+}
+
+// fields returns the metadata fields that are required by the template.
+func (t *template) fields() []metaField {
+	var fl []metaField
+	for _, p := range t.parts {
+		if !p.isVar {
+			continue
+		}
+		name := p.text
+		if i := strings.IndexByte(name, '.'); i > 0 && (name[:i] == "first" || name[:i] == "last") {
+			name = name[i+1:]
+		}
+		var names []string
+		switch {
+		case name == "location":
+			names = locationFields
+		case strings.HasPrefix(name, "exif."):
+			fl = append(fl, exifField(name))
+		default:
+			names = []string{name}
+		}
+		for _, n := range names {
+			if f, ok := templateFields[n]; ok {
+				fl = append(fl, f)
+			}
+		}
+	}
+	return fl
+}
+
+// exifField returns the field for an "exif." variable. The key is either a full
+// metadata key (e.g exif.Iptc.Application2.Byline) or the name of an EXIF tag (e.g exif.Model).
+func exifField(name string) metaField {
+	k := strings.TrimPrefix(name, "exif.")
+	if strings.Contains(k, ".") {
+		return metaField{name, []string{k}}
+	}
+	return metaField{name, []string{"Exif.Image." + k, "Exif.Photo." + k}}
+}
+
+// expand returns the text of the template, using the function to obtain the variable values.
+func (t *template) expand(value func(name, format string) string) string {
+	var s strings.Builder
+	for _, p := range t.parts {
+		if p.isVar {
+			s.WriteString(value(p.text, p.format))
+		} else {
+			s.WriteString(p.text)
+		}
+	}
+	return strings.TrimSpace(s.String())
+}
+
+// photoValue returns the value of a picture variable.
+func (p *Pict) photoValue(name, format string) string {
+	exif := p.exif
+	if exif == nil {
+		return ""
+	}
+	switch name {
+	case "date":
+		if format == "" {
+			format = templateDateLayout
+		}
+		return exif.ts.Format(format)
+	case "name":
+		return p.baseName
+	case "title":
+		return exif.title
+	case "caption":
+		return exif.caption
+	case "location":
+		var loc []string
+		for _, f := range locationFields {
+			if v := exif.fields[f]; v != "" && !slices.Contains(loc, v) {
+				loc = append(loc, v)
+			}
+		}
+		return strings.Join(loc, ", ")
+	}
+	return exif.fields[name]
+}
+
+// galleryValue returns the value of a gallery variable.
+func galleryValue(picts []*Pict, name, format string) string {
+	switch name {
+	case "count":
+		return strconv.Itoa(len(picts))
+	case "date_range":
+		if len(picts) == 0 {
+			return ""
+		}
+		first, last := picts[0].exif.ts, picts[0].exif.ts
+		for _, p := range picts[1:] {
+			if p.exif.ts.Before(first) {
+				first = p.exif.ts
+			}
+			if p.exif.ts.After(last) {
+				last = p.exif.ts
+			}
+		}
+		return dateRange(first, last, format)
+	}
+	if len(picts) == 0 {
+		return ""
+	}
+	if v, ok := strings.CutPrefix(name, "first."); ok {
+		return picts[0].photoValue(v, format)
+	}
+	if v, ok := strings.CutPrefix(name, "last."); ok {
+		return picts[len(picts)-1].photoValue(v, format)
+	}
+	return ""
+}
+
+// dateRange formats the range of dates, omitting the parts that are common to both dates,
+// e.g "3 - 5 March 2015". If a layout is provided, both dates are formatted using the layout.
+func dateRange(first, last time.Time, layout string) string {
+	if layout != "" {
+		f, l := first.Format(layout), last.Format(layout)
+		if f == l {
+			return f
+		}
+		return f + " - " + l
+	}
+	switch {
+	case first.Year() != last.Year():
+		return first.Format(templateDateLayout) + " - " + last.Format(templateDateLayout)
+	case first.Month() != last.Month():
+		return first.Format("2 January") + " - " + last.Format(templateDateLayout)
+	case first.Day() != last.Day():
+		return first.Format("2") + " - " + last.Format(templateDateLayout)
+	}
+	return first.Format(templateDateLayout)
+}
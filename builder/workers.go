@@ -0,0 +1,189 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	bar "github.com/schollz/progressbar/v3"
+)
+
+// TimeoutError is returned for a task that has not completed within the task timeout.
+type TimeoutError struct {
+	Task    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out after %v", e.Task, e.Timeout)
+}
+
+// task is a named function to be run on the worker pool.
+type task struct {
+	name string
+	cost func() int64 // Estimated memory of the task, or nil
+	f    func(ctx context.Context) error
+}
+
+type Worker struct {
+	ctx     context.Context
+	ch      chan task
+	slots   chan struct{} // Limits the tasks running, including those that have timed out
+	wg      sync.WaitGroup
+	timeout time.Duration
+	errMu   sync.Mutex
+	errs    []error
+	taskErr map[string]error
+	budget  *memoryBudget // If set, limits the memory of the tasks running concurrently
+	bar     *bar.ProgressBar
+}
+
+// NewWorker creates a worker pool running up to limit tasks concurrently (or the number
+// of CPUs if limit is 0). If timeout is non-zero, each task is given a deadline, and
+// a task that does not complete in time is abandoned and a TimeoutError recorded.
+// The tasks are passed a context that is cancelled when the pool's context is
+// cancelled, or when the task's deadline expires. An abandoned task still counts
+// against the limit until it returns, so that no more than limit tasks are ever running.
+// If count and name are set, a progress bar is created.
+func NewWorker(ctx context.Context, limit int, timeout time.Duration, name string, count int) *Worker {
+	// Create a set of workers that listen on a channel and
+	// call a function.
+	w := &Worker{ctx: ctx, timeout: timeout, taskErr: make(map[string]error)}
+	if count != 0 && name != "" {
+		w.bar = bar.Default(int64(count), name)
+	}
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+	w.ch = make(chan task, limit)
+	w.slots = make(chan struct{}, limit)
+	w.wg.Add(limit)
+	for range limit {
+		go w.worker()
+	}
+	return w
+}
+
+// Wait shuts down the worker pool by closing the channel and
+// waits for the workers to finish.
+func (w *Worker) Wait() {
+	close(w.ch)
+	w.wg.Wait()
+	if w.bar != nil {
+		w.bar.Finish()
+	}
+}
+
+// Execute a named function on one of the workers.
+// If the function returns an error, the error is recorded.
+// Tasks submitted after the pool's context is cancelled are not run.
+func (w *Worker) Run(name string, f func(ctx context.Context) error) {
+	w.ch <- task{name: name, f: f}
+}
+
+// RunWeighted executes a named function on one of the workers once the estimated memory
+// returned by cost is available in the worker pool's memory budget. The cost is evaluated on the
+// worker, and the task's deadline starts once the memory is available.
+func (w *Worker) RunWeighted(name string, cost func() int64, f func(ctx context.Context) error) {
+	w.ch <- task{name: name, cost: cost, f: f}
+}
+
+// Failed returns true if any of the functions have returned an error.
+func (w *Worker) Failed() bool {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return len(w.errs) != 0
+}
+
+// Errors returns the errors returned by the functions, in the order they occurred.
+func (w *Worker) Errors() []error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return append([]error(nil), w.errs...)
+}
+
+// Err returns the error of the named task, if any.
+func (w *Worker) Err(name string) error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.taskErr[name]
+}
+
+// worker listens for a function to dispatch and then calls it.
+// When the channel closes, exit.
+func (w *Worker) worker() {
+	defer w.wg.Done()
+	for t := range w.ch {
+		if w.ctx.Err() == nil {
+			if err := w.admit(t); err != nil {
+				w.errMu.Lock()
+				// Only the first error of a task is recorded, since a task
+				// that has timed out may return an error later.
+				if _, ok := w.taskErr[t.name]; !ok {
+					w.taskErr[t.name] = err
+					w.errs = append(w.errs, err)
+				}
+				w.errMu.Unlock()
+			}
+		}
+		if w.bar != nil {
+			w.bar.Add(1)
+		}
+	}
+}
+
+// admit waits for a slot to be free (since abandoned tasks may still be running) and for
+// the task's memory to be available in the budget, and runs the task.
+func (w *Worker) admit(t task) error {
+	select {
+	case w.slots <- struct{}{}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	var n int64
+	if w.budget != nil && t.cost != nil {
+		var err error
+		if n, err = w.budget.acquire(w.ctx, t.cost()); err != nil {
+			<-w.slots
+			return err
+		}
+	}
+	return w.run(t, n)
+}
+
+// release returns the task's memory to the budget, and frees the task's slot.
+func (w *Worker) release(mem int64) {
+	w.budget.release(mem)
+	<-w.slots
+}
+
+// run calls the task's function. If there is a timeout, the function is
+// run in a separate goroutine, and abandoned if the deadline expires (the task's context
+// is cancelled so that the function can stop at the next opportunity).
+// The task's memory and slot are released when the function returns, so an
+// abandoned task keeps its memory and slot until it has actually finished.
+func (w *Worker) run(t task, mem int64) error {
+	if w.timeout == 0 {
+		defer w.release(mem)
+		return t.f(w.ctx)
+	}
+	ctx, cancel := context.WithTimeout(w.ctx, w.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer w.release(mem)
+		done <- t.f(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if w.ctx.Err() != nil {
+			// The pool has been cancelled, so wait for the task to clean up.
+			return <-done
+		}
+		return &TimeoutError{Task: t.name, Timeout: w.timeout}
+	}
+}
@@ -0,0 +1,47 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWorkerTimeout checks that tasks that time out are reported, and that tasks
+// that have timed out but are still running count against the worker limit.
+func TestWorkerTimeout(t *testing.T) {
+	const limit = 2
+	w := NewWorker(context.Background(), limit, 10*time.Millisecond, "", 0)
+	var mu sync.Mutex
+	running, peak := 0, 0
+	var tasks sync.WaitGroup
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	tasks.Add(len(names))
+	for _, n := range names {
+		w.Run(n, func(ctx context.Context) error {
+			defer tasks.Done()
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+			// Ignore the context, as a stuck decoder would.
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+	}
+	w.Wait()
+	tasks.Wait()
+	if peak > limit {
+		t.Errorf("got %d tasks running, want at most %d", peak, limit)
+	}
+	for _, n := range names {
+		var te *TimeoutError
+		if err := w.Err(n); !errors.As(err, &te) || te.Task != n {
+			t.Errorf("%s: got error %v, want timeout", n, err)
+		}
+	}
+}
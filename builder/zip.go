@@ -0,0 +1,144 @@
+package builder
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const zipFile = "photos.zip"
+
+// Prefix of the zip file comment holding the fingerprint of the contents.
+const zipCommentPrefix = "pweb:"
+
+// Extensions of file formats that are already compressed, and are
+// stored in the zip file without compression.
+var compressedExt = []string{".jpg", ".jpeg", ".webp", ".avif", ".heic", ".png"}
+
+// zipEntry is a file to be added to the zip file.
+type zipEntry struct {
+	name   string // Name of the file in the zip file
+	src    string // Path of the file to be read
+	size   int64
+	mtime  time.Time
+	method uint16
+}
+
+// updateZip writes a zip file to the download directory containing the downloadable
+// files of the pictures. Symlinks in the download directory are followed, so the original
+// files are read. If configured, the files paired with the pictures are also added.
+// The zip file is only rewritten if the list of files, or their modified times,
+// have changed. The zip file is written to a temporary file and renamed, so that an existing zip
+// file is always complete. If store is set, already compressed images are stored uncompressed.
+func (b *build) updateZip(dlDir string, picts []*Pict, store bool) error {
+	var entries []zipEntry
+	add := func(dlFile string) error {
+		src := path.Join(b.destDir, dlFile)
+		st, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if !st.Mode().IsRegular() {
+			return fmt.Errorf("%s: not a regular file", src)
+		}
+		e := zipEntry{name: path.Base(dlFile), src: src, size: st.Size(), mtime: st.ModTime(), method: zip.Deflate}
+		if store && isCompressed(e.name) {
+			e.method = zip.Store
+		}
+		entries = append(entries, e)
+		return nil
+	}
+	for _, p := range picts {
+		if err := add(p.dlFile); err != nil {
+			return err
+		}
+		if b.conf.Pair == PAIR_ZIP {
+			for _, e := range p.extras {
+				if err := add(e.dlFile); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	zipPath := path.Join(dlDir, zipFile)
+	fp := zipFingerprint(entries)
+	if r, err := zip.OpenReader(zipPath); err == nil {
+		current := r.Comment == fp
+		r.Close()
+		if current {
+			b.verbosef("%s is up to date\n", zipPath)
+			return nil
+		}
+	}
+	b.statusf("Updating downloads\n")
+	tmp, err := os.CreateTemp(dlDir, ".photos-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeZip(tmp, entries, fp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), zipPath)
+}
+
+// writeZip writes the entries to the zip file.
+func writeZip(w io.Writer, entries []zipEntry, comment string) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: e.method, Modified: e.mtime}
+		hdr.SetMode(0644)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(e.src)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.src, err)
+		}
+	}
+	if err := zw.SetComment(comment); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// zipFingerprint generates a fingerprint of the zip file contents, which is stored
+// as the zip file comment.
+func zipFingerprint(entries []zipEntry) string {
+	h := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\n", e.name, e.size, e.mtime.UnixNano(), e.method)
+	}
+	return zipCommentPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// isCompressed returns true if the file is an already compressed image format.
+func isCompressed(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, c := range compressedExt {
+		if ext == c {
+			return true
+		}
+	}
+	return false
+}
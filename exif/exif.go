@@ -0,0 +1,13 @@
+package exif
+
+// MetadataReader defines the interface to a reader of image metadata.
+// Keys use the exiv2 naming style e.g "Exif.Photo.FNumber",
+// "Iptc.Application2.Caption" or "Xmp.xmp.Rating". A language alternative of an XMP
+// property may be selected by adding the language in brackets e.g "Xmp.dc.title[de]".
+type MetadataReader interface {
+	// Get returns the value of the first key found, or an empty string.
+	Get(keys ...string) string
+	// GetList returns the values of the first key found, with a value for each item of
+	// an array or repeated dataset (such as the IPTC keywords), or nil if no key is found.
+	GetList(keys ...string) []string
+}
@@ -1,9 +1,13 @@
+//go:build !noexiv2
+
 package exiv2
 
 import (
 	"os"
 	"strings"
 
+	"github.com/aamcrae/pweb/exif"
+	"github.com/aamcrae/pweb/exif/xmp"
 	"github.com/kolesa-team/goexiv"
 )
 
@@ -13,7 +17,7 @@ type exiv2 struct {
 
 // goexiv (a cgo binding to libexiv2) is used, but sometimes it seems
 // this binding doesn't handle concurrency reliably, and will sometimes crash unexpectedly.
-func Exiv2Open(file string) (*exiv2, error) {
+func Exiv2Open(file string) (exif.MetadataReader, error) {
 	idata, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
@@ -28,7 +32,7 @@ func Exiv2Open(file string) (*exiv2, error) {
 		// Unable to parse exif
 		return nil, err
 	}
-	return &exiv2{ img: img}, nil
+	return &exiv2{img: img}, nil
 }
 
 func (r *exiv2) Get(keys ...string) string {
@@ -46,11 +50,84 @@ func (r *exiv2) Get(keys ...string) string {
 				return v.String()
 			}
 		case "Xmp":
-			xmp := r.img.GetXmpData()
-			if v, err := xmp.FindKey(k); err == nil && v != nil {
-				return v.String()
+			key, lang, hasLang := xmp.SplitLang(k)
+			xdata := r.img.GetXmpData()
+			if v, err := xdata.FindKey(key); err == nil && v != nil {
+				s := v.String()
+				if !strings.HasPrefix(s, `lang="`) {
+					if !hasLang {
+						return s
+					}
+				} else if alt := langAlt(s, lang, hasLang); alt != "" {
+					return alt
+				}
 			}
 		}
 	}
 	return ""
 }
+
+// GetList returns the values of the first key found. Repeated IPTC datasets have a value for
+// each dataset. exiv2 joins the items of XMP arrays using ", ", so these are split on ", ".
+func (r *exiv2) GetList(keys ...string) []string {
+	for _, k := range keys {
+		b, _, _ := strings.Cut(k, ".")
+		switch b {
+		case "Iptc":
+			var l []string
+			for i := r.img.GetIptcData().Iterator(); i.HasNext(); {
+				if d := i.Next(); d.Key() == k {
+					l = append(l, d.String())
+				}
+			}
+			if len(l) > 0 && l[0] != "" {
+				return l
+			}
+		case "Xmp":
+			if _, _, hasLang := xmp.SplitLang(k); !hasLang {
+				if v, err := r.img.GetXmpData().FindKey(k); err == nil && v != nil {
+					if s := v.String(); s != "" && !strings.HasPrefix(s, `lang="`) {
+						return strings.Split(s, ", ")
+					}
+				}
+			}
+			if v := r.Get(k); v != "" {
+				return []string{v}
+			}
+		default:
+			if v := r.Get(k); v != "" {
+				return []string{v}
+			}
+		}
+	}
+	return nil
+}
+
+// langAlt selects a value from a language alternative, which exiv2 formats as
+// `lang="x-default" Text, lang="de-DE" Text`. If a language is not selected,
+// the x-default value (or the first value) is returned.
+func langAlt(s, lang string, hasLang bool) string {
+	alts := make(map[string]string)
+	var first string
+	for _, a := range strings.Split(s, `, lang="`) {
+		a = strings.TrimPrefix(a, `lang="`)
+		l, v, ok := strings.Cut(a, `" `)
+		if !ok {
+			continue
+		}
+		if first == "" {
+			first = v
+		}
+		alts[strings.ToLower(l)] = v
+	}
+	if !hasLang {
+		if v, ok := alts["x-default"]; ok {
+			return v
+		}
+		return first
+	}
+	if v, ok := alts[strings.ToLower(lang)]; ok {
+		return v
+	}
+	return xmp.MatchLang(alts, lang)
+}
@@ -0,0 +1,15 @@
+//go:build noexiv2
+
+package exiv2
+
+import (
+	"errors"
+
+	"github.com/aamcrae/pweb/exif"
+)
+
+// Exiv2Open returns an error when pweb is built without libexiv2
+// (using the noexiv2 build tag).
+func Exiv2Open(file string) (exif.MetadataReader, error) {
+	return nil, errors.New("exiv2 support not available (built with noexiv2)")
+}
@@ -1,37 +1,120 @@
 package goexif
 
 import (
-	_ "fmt"
-	"github.com/dsoprea/go-exif/v3"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/aamcrae/pweb/exif"
+	"github.com/aamcrae/pweb/exif/xmp"
+	exifv3 "github.com/dsoprea/go-exif/v3"
 )
 
+// ifdPaths maps the exiv2 EXIF group names to the go-exif IFD paths.
+var ifdPaths = map[string]string{
+	"Image":     "IFD",
+	"Photo":     "IFD/Exif",
+	"GPSInfo":   "IFD/GPSInfo",
+	"Iop":       "IFD/Exif/Iop",
+	"Thumbnail": "IFD1",
+}
+
+// goexif is a pure Go metadata reader. EXIF tags are read using go-exif,
+// and the IPTC and XMP metadata is extracted from the JPEG segments.
 type goexif struct {
-	tagMap map[string]*exif.ExifTag
+	tagMap map[string]*exifv3.ExifTag
+	iptc   map[string][]string // Values of the IPTC datasets, with a value for each repeated dataset
+	xmp    *xmp.Xmp
 }
 
-func GoExifOpen(file string) (*goexif, error) {
-	edata, err := exif.SearchFileAndExtractExif(file)
+func GoExifOpen(file string) (exif.MetadataReader, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	tagMap := make(map[string]*exif.ExifTag)
-	// Extract all tags
-	tags, _, err := exif.GetFlatExifDataUniversalSearch(edata, nil, true)
-	if err != nil {
+	tagMap := make(map[string]*exifv3.ExifTag)
+	edata, err := exifv3.SearchAndExtractExif(data)
+	if err == nil {
+		// Extract all tags
+		tags, _, err := exifv3.GetFlatExifDataUniversalSearch(edata, nil, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			tagMap[t.IfdPath+"."+t.TagName] = &t
+		}
+	} else if !errors.Is(err, exifv3.ErrNoExif) {
 		return nil, err
 	}
-	for _, t := range tags {
-		tagMap[t.TagName] = &t
+	r := &goexif{tagMap: tagMap, iptc: make(map[string][]string)}
+	// Search the JPEG segments for IPTC and XMP data.
+	var xmpErr error
+	segments(data, func(marker byte, seg []byte) {
+		switch {
+		case marker == app1 && strings.HasPrefix(string(seg), xmp.Header):
+			r.xmp, xmpErr = xmp.Parse(seg[len(xmp.Header):])
+		case marker == app13 && strings.HasPrefix(string(seg), photoshopHeader):
+			parseIptc(seg[len(photoshopHeader):], r.iptc)
+		}
+	})
+	if xmpErr != nil {
+		return nil, xmpErr
 	}
-	return &goexif{tagMap: tagMap}, nil
+	return r, nil
 }
 
 func (r *goexif) Get(keys ...string) string {
 	for _, k := range keys {
-		v, ok := r.tagMap[k]
-		if ok {
-			return v.Formatted
+		b, name, _ := strings.Cut(k, ".")
+		switch b {
+		case "Iptc":
+			if v := r.iptc[k]; len(v) > 0 && v[0] != "" {
+				return v[0]
+			}
+		case "Exif":
+			group, tag, _ := strings.Cut(name, ".")
+			if v, ok := r.tagMap[ifdPaths[group]+"."+tag]; ok {
+				return format(v)
+			}
+		case "Xmp":
+			if r.xmp != nil {
+				if v := r.xmp.Get(k); v != "" {
+					return v
+				}
+			}
 		}
 	}
 	return ""
 }
+
+func (r *goexif) GetList(keys ...string) []string {
+	for _, k := range keys {
+		b, _, _ := strings.Cut(k, ".")
+		switch b {
+		case "Iptc":
+			if v := r.iptc[k]; len(v) > 0 && v[0] != "" {
+				return v
+			}
+		case "Xmp":
+			if r.xmp != nil {
+				if v := r.xmp.GetList(k); len(v) > 0 {
+					return v
+				}
+			}
+		default:
+			if v := r.Get(k); v != "" {
+				return []string{v}
+			}
+		}
+	}
+	return nil
+}
+
+// format converts the tag value to the same format as exiv2, where
+// multiple values are separated by spaces.
+func format(t *exifv3.ExifTag) string {
+	if _, ok := t.Value.(string); ok {
+		return t.Formatted
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(t.Formatted, "["), "]")
+}
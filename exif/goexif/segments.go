@@ -0,0 +1,118 @@
+package goexif
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// JPEG markers
+const (
+	soi   = 0xD8
+	sos   = 0xDA
+	eoi   = 0xD9
+	app1  = 0xE1
+	app13 = 0xED
+)
+
+const photoshopHeader = "Photoshop 3.0\x00"
+
+// iptcDatasets maps the IPTC Application Record datasets to the exiv2 key names.
+var iptcDatasets = map[byte]string{
+	5:   "Iptc.Application2.ObjectName",
+	15:  "Iptc.Application2.Category",
+	25:  "Iptc.Application2.Keywords",
+	40:  "Iptc.Application2.SpecialInstructions",
+	55:  "Iptc.Application2.DateCreated",
+	60:  "Iptc.Application2.TimeCreated",
+	80:  "Iptc.Application2.Byline",
+	90:  "Iptc.Application2.City",
+	92:  "Iptc.Application2.SubLocation",
+	95:  "Iptc.Application2.ProvinceState",
+	101: "Iptc.Application2.CountryName",
+	105: "Iptc.Application2.Headline",
+	110: "Iptc.Application2.Credit",
+	115: "Iptc.Application2.Source",
+	116: "Iptc.Application2.Copyright",
+	120: "Iptc.Application2.Caption",
+	122: "Iptc.Application2.Writer",
+}
+
+// segments calls f for each of the JPEG marker segments that precede the image data,
+// passing the marker and the segment payload.
+func segments(data []byte, f func(marker byte, seg []byte)) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != soi {
+		return
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == sos || marker == eoi {
+			return
+		}
+		l := int(binary.BigEndian.Uint16(data[i+2:]))
+		if l < 2 || i+2+l > len(data) {
+			return
+		}
+		f(marker, data[i+4:i+2+l])
+		i += 2 + l
+	}
+}
+
+// parseIptc extracts the IPTC records from the Photoshop image resource blocks
+// in an APP13 segment. Repeated datasets (such as the keywords) have a value for each dataset.
+func parseIptc(data []byte, iptc map[string][]string) {
+	for len(data) >= 12 && string(data[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[4:])
+		// Skip the name, which is a padded pascal string.
+		nl := int(data[6]) + 1
+		nl += nl & 1
+		if 6+nl+4 > len(data) {
+			return
+		}
+		data = data[6+nl:]
+		size := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if size > len(data) {
+			return
+		}
+		if id == 0x0404 {
+			parseRecords(data[:size], iptc)
+		}
+		data = data[min(size+size&1, len(data)):]
+	}
+}
+
+// parseRecords parses the IPTC-IIM datasets.
+func parseRecords(data []byte, iptc map[string][]string) {
+	for len(data) >= 5 && data[0] == 0x1C {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:]))
+		if size&0x8000 != 0 || 5+size > len(data) {
+			// Extended datasets are not used for text values.
+			return
+		}
+		if k, ok := iptcDatasets[dataset]; ok && record == 2 {
+			iptc[k] = append(iptc[k], toUTF8(data[5:5+size]))
+		}
+		data = data[5+size:]
+	}
+}
+
+// toUTF8 returns the string as UTF-8, assuming ISO 8859-1 if not already valid UTF-8.
+func toUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
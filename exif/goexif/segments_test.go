@@ -0,0 +1,60 @@
+package goexif
+
+import (
+	"encoding/binary"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// iptcJPEG returns a JPEG header with an APP13 segment containing the IPTC datasets.
+func iptcJPEG(datasets map[byte][]string) []byte {
+	var records []byte
+	for _, ds := range slices.Sorted(maps.Keys(datasets)) {
+		for _, v := range datasets[ds] {
+			records = append(records, 0x1C, 2, ds)
+			records = binary.BigEndian.AppendUint16(records, uint16(len(v)))
+			records = append(records, v...)
+		}
+	}
+	// Photoshop image resource block with an empty name.
+	seg := []byte(photoshopHeader + "8BIM\x04\x04\x00\x00")
+	seg = binary.BigEndian.AppendUint32(seg, uint32(len(records)))
+	seg = append(seg, records...)
+	if len(records)&1 != 0 {
+		seg = append(seg, 0)
+	}
+	data := []byte{0xFF, soi, 0xFF, app13}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(seg)+2))
+	data = append(data, seg...)
+	return append(data, 0xFF, eoi)
+}
+
+func TestIptcKeywords(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "a.jpg")
+	data := iptcJPEG(map[byte][]string{
+		5:  {"Summit"},
+		25: {"Birds", "Tawau, Sabah", "Kinabalu"},
+	})
+	if err := os.WriteFile(f, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GoExifOpen(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.GetList("Iptc.Application2.Keywords"), []string{"Birds", "Tawau, Sabah", "Kinabalu"}; !slices.Equal(got, want) {
+		t.Errorf("keywords: got %q, want %q", got, want)
+	}
+	if got := r.Get("Iptc.Application2.Keywords"); got != "Birds" {
+		t.Errorf("first keyword: got %q, want %q", got, "Birds")
+	}
+	if got, want := r.GetList("Iptc.Application2.Headline", "Iptc.Application2.ObjectName"), []string{"Summit"}; !slices.Equal(got, want) {
+		t.Errorf("object name: got %q, want %q", got, want)
+	}
+	if got := r.GetList("Iptc.Application2.City"); got != nil {
+		t.Errorf("city: got %q, want none", got)
+	}
+}
@@ -0,0 +1,42 @@
+package goexif
+
+import (
+	"io"
+	"os"
+
+	exifv3 "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// Maximum amount of the file searched for the EXIF data (a JPEG APP1 segment
+// is limited to 64K, but may follow other segments).
+const thumbnailSearch = 256 * 1024
+
+// Thumbnail returns the JPEG thumbnail embedded in the EXIF data of the image file.
+func Thumbnail(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, thumbnailSearch))
+	if err != nil {
+		return nil, err
+	}
+	edata, err := exifv3.SearchAndExtractExif(data)
+	if err != nil {
+		return nil, err
+	}
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return nil, err
+	}
+	_, index, err := exifv3.Collect(im, exifv3.NewTagIndex(), edata)
+	if err != nil {
+		return nil, err
+	}
+	if ifd := index.RootIfd.NextIfd(); ifd != nil {
+		return ifd.Thumbnail()
+	}
+	return nil, exifv3.ErrNoThumbnail
+}
@@ -0,0 +1,274 @@
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+const xmlNS = "http://www.w3.org/XML/1998/namespace"
+
+// prefixes maps the XMP namespaces to the prefixes used by exiv2 in
+// the key names, since files may use other prefixes (e.g "xap" instead of "xmp").
+var prefixes = map[string]string{
+	"http://ns.adobe.com/xap/1.0/":                 "xmp",
+	"http://ns.adobe.com/xap/1.0/mm/":              "xmpMM",
+	"http://ns.adobe.com/xap/1.0/rights/":          "xmpRights",
+	"http://purl.org/dc/elements/1.1/":             "dc",
+	"http://ns.adobe.com/tiff/1.0/":                "tiff",
+	"http://ns.adobe.com/exif/1.0/":                "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":            "aux",
+	"http://ns.adobe.com/photoshop/1.0/":           "photoshop",
+	"http://ns.adobe.com/lightroom/1.0/":           "lr",
+	"http://ns.adobe.com/camera-raw-settings/1.0/": "crs",
+	"http://ns.adobe.com/pdf/1.3/":                 "pdf",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/":  "iptc",
+	"http://iptc.org/std/Iptc4xmpExt/2008-02-29/":  "iptcExt",
+	"http://ns.microsoft.com/photo/1.0/":           "MicrosoftPhoto",
+	"http://darktable.sf.net/":                     "darktable",
+}
+
+// Xmp holds the properties parsed from an XMP packet.
+// Properties are keyed using the exiv2 style (e.g "Xmp.xmp.Rating").
+// Arrays (rdf:Bag and rdf:Seq) are joined using ", " (GetList returns the items), and language
+// alternatives (rdf:Alt) use the x-default entry, or the first entry.
+// A particular language alternative can be selected by adding the language
+// to the key in brackets e.g "Xmp.dc.title[de-DE]".
+type Xmp struct {
+	props map[string]string
+	lists map[string][]string          // Items of the arrays
+	langs map[string]map[string]string // Language alternatives, keyed by lower case language
+	ns    map[string]string            // Namespaces declared in the packet
+}
+
+// Header is the signature preceding the XMP packet in a JPEG APP1 segment.
+const Header = "http://ns.adobe.com/xap/1.0/\x00"
+
+// Parse parses the XMP packet and extracts the properties.
+func Parse(b []byte) (*Xmp, error) {
+	x := &Xmp{props: make(map[string]string), lists: make(map[string][]string), langs: make(map[string]map[string]string), ns: make(map[string]string)}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			x.namespaces(se)
+			if se.Name.Space == rdfNS && se.Name.Local == "Description" {
+				if err := x.description(d, se); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+// Get returns the value of the first key found.
+func (x *Xmp) Get(keys ...string) string {
+	for _, k := range keys {
+		if key, lang, ok := SplitLang(k); ok {
+			if v := x.lang(key, lang); v != "" {
+				return v
+			}
+		} else if v, ok := x.props[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// GetList returns the items of the first key found. A value that is not an array is returned as a single item.
+func (x *Xmp) GetList(keys ...string) []string {
+	for _, k := range keys {
+		if l, ok := x.lists[k]; ok {
+			return l
+		}
+		if v := x.Get(k); v != "" {
+			return []string{v}
+		}
+	}
+	return nil
+}
+
+// SplitLang splits a key of the form "key[lang]" into the key and the language.
+func SplitLang(k string) (string, string, bool) {
+	if !strings.HasSuffix(k, "]") {
+		return k, "", false
+	}
+	key, lang, ok := strings.Cut(strings.TrimSuffix(k, "]"), "[")
+	return key, lang, ok && lang != ""
+}
+
+// lang returns the language alternative of the property. If there is no exact match
+// of the language, an alternative with the same primary language is used (e.g "en-GB" for "en").
+func (x *Xmp) lang(key, lang string) string {
+	alts := x.langs[key]
+	lang = strings.ToLower(lang)
+	if v, ok := alts[lang]; ok {
+		return v
+	}
+	return MatchLang(alts, lang)
+}
+
+// MatchLang returns the value of the language alternative with the same primary language,
+// selecting the lowest language tag if there are several.
+func MatchLang(alts map[string]string, lang string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	var best, v string
+	for l, alt := range alts {
+		if p, _, _ := strings.Cut(l, "-"); p == primary && (best == "" || l < best) {
+			best, v = l, alt
+		}
+	}
+	return v
+}
+
+// description extracts the properties from a rdf:Description element, either
+// as attributes or as child elements.
+func (x *Xmp) description(d *xml.Decoder, se xml.StartElement) error {
+	for _, a := range se.Attr {
+		if k, ok := x.key(a.Name); ok {
+			x.props[k] = a.Value
+		}
+	}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			x.namespaces(t)
+			if err := x.property(d, t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// property reads a single property element, which may be
+// a simple value, or an array of values.
+func (x *Xmp) property(d *xml.Decoder, se xml.StartElement) error {
+	var text strings.Builder
+	var items []string
+	var def string
+	langs := make(map[string]string)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			x.namespaces(t)
+			switch {
+			case t.Name.Space == rdfNS && t.Name.Local == "li":
+				v, err := readText(d)
+				if err != nil {
+					return err
+				}
+				items = append(items, v)
+				if lang := attr(t, xmlNS, "lang"); lang == "x-default" {
+					def = v
+				} else if lang != "" {
+					langs[strings.ToLower(lang)] = v
+				}
+			case t.Name.Space == rdfNS && (t.Name.Local == "Alt" || t.Name.Local == "Bag" || t.Name.Local == "Seq"):
+				// The list items are processed as they are read.
+			default:
+				// Structures are not supported.
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			if t.Name != se.Name {
+				// End of the array.
+				continue
+			}
+			k, ok := x.key(se.Name)
+			if !ok {
+				return nil
+			}
+			if len(langs) > 0 {
+				x.langs[k] = langs
+			}
+			switch {
+			case def != "":
+				x.props[k] = def
+			case len(items) > 0:
+				x.props[k] = strings.Join(items, ", ")
+				x.lists[k] = items
+			default:
+				x.props[k] = strings.TrimSpace(text.String())
+			}
+			return nil
+		}
+	}
+}
+
+// namespaces records any namespace prefixes declared in the element.
+func (x *Xmp) namespaces(se xml.StartElement) {
+	for _, a := range se.Attr {
+		if a.Name.Space == "xmlns" {
+			x.ns[a.Value] = a.Name.Local
+		}
+	}
+}
+
+// key maps the element or attribute name to the exiv2 style key.
+// RDF and XML attributes are not properties, and are ignored.
+func (x *Xmp) key(n xml.Name) (string, bool) {
+	switch n.Space {
+	case "", rdfNS, xmlNS, "xmlns", "adobe:ns:meta/":
+		return "", false
+	}
+	p, ok := prefixes[n.Space]
+	if !ok {
+		if p, ok = x.ns[n.Space]; !ok {
+			return "", false
+		}
+	}
+	return "Xmp." + p + "." + n.Local, true
+}
+
+// readText reads the character data of an element, skipping any child elements.
+func readText(d *xml.Decoder) (string, error) {
+	var text strings.Builder
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := t.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			if err := d.Skip(); err != nil {
+				return "", err
+			}
+		case xml.EndElement:
+			return strings.TrimSpace(text.String()), nil
+		}
+	}
+}
+
+// attr returns the value of the named attribute.
+func attr(se xml.StartElement, space, local string) string {
+	for _, a := range se.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+// exifcompare reads the metadata of a set of images using both the exiv2
+// and goexif backends, and reports any differences in the values
+// used by pweb.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/aamcrae/pweb/exif/exiv2"
+	"github.com/aamcrae/pweb/exif/goexif"
+)
+
+var verbose = flag.Bool("verbose", false, "Print all values")
+
+// keys are the metadata keys that pweb reads.
+var keys = []string{
+	"Iptc.Application2.ObjectName",
+	"Iptc.Application2.Headline",
+	"Iptc.Application2.Caption",
+	"Exif.Photo.ExposureTime",
+	"Exif.Photo.ISOSpeedRatings",
+	"Exif.Photo.FNumber",
+	"Exif.Photo.FocalLength",
+	"Exif.Image.Orientation",
+	"Exif.Photo.DateTimeDigitized",
+	"Exif.Photo.DateTimeOriginal",
+	"Exif.Image.DateTime",
+	"Xmp.tiff.ImageWidth",
+	"Xmp.tiff.ImageLength",
+	"Exif.Photo.PixelXDimension",
+	"Exif.Photo.PixelYDimension",
+	"Xmp.xmp.Rating",
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] image-file|directory ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	var files []string
+	for _, a := range flag.Args() {
+		if st, err := os.Stat(a); err != nil {
+			log.Fatalf("%s: %v", a, err)
+		} else if st.IsDir() {
+			fl, err := filepath.Glob(filepath.Join(a, "*.[jJ][pP][gG]"))
+			if err != nil {
+				log.Fatalf("%s: %v", a, err)
+			}
+			files = append(files, fl...)
+		} else {
+			files = append(files, a)
+		}
+	}
+	diffs := 0
+	for _, f := range files {
+		diffs += compare(f)
+	}
+	fmt.Printf("%d files, %d differences\n", len(files), diffs)
+	if diffs != 0 {
+		os.Exit(1)
+	}
+}
+
+// compare reads the file using both backends and compares the values
+// of each of the keys, returning the number of differences.
+func compare(file string) int {
+	exv, err := exiv2.Exiv2Open(file)
+	if err != nil {
+		log.Fatalf("%s: exiv2: %v", file, err)
+	}
+	gex, err := goexif.GoExifOpen(file)
+	if err != nil {
+		log.Fatalf("%s: goexif: %v", file, err)
+	}
+	diffs := 0
+	for _, k := range keys {
+		v1, v2 := exv.Get(k), gex.Get(k)
+		if v1 != v2 {
+			fmt.Printf("%s: %s: exiv2 <%s>, goexif <%s>\n", file, k, v1, v2)
+			diffs++
+		} else if *verbose {
+			fmt.Printf("%s: %s: <%s>\n", file, k, v1)
+		}
+	}
+	return diffs
+}
@@ -1,8 +1,9 @@
 module github.com/aamcrae/pweb
 
-go 1.22.2
+go 1.24
 
 require (
+	github.com/HugoSmits86/nativewebp v0.9.3
 	github.com/aamcrae/wasm v0.1.0
 	github.com/davidbyttow/govips/v2 v2.15.0
 	github.com/disintegration/imaging v1.6.2
@@ -10,6 +11,7 @@ require (
 	github.com/kolesa-team/goexiv v1.2.0
 	github.com/schollz/progressbar/v3 v3.14.4
 	github.com/thomasheller/braceexpansion v0.0.0-20201129203016-fc18a386c29f
+	golang.org/x/image v0.10.0
 )
 
 require (
@@ -20,7 +22,6 @@ require (
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/thomasheller/slicecmp v0.0.0-20191029144834-595e9211ce09 // indirect
-	golang.org/x/image v0.10.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/term v0.20.0 // indirect
@@ -1,19 +1,49 @@
 package dis
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"os"
 	"time"
 
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/aamcrae/pweb/exif/goexif"
 	"github.com/aamcrae/pweb/imager"
+	"github.com/aamcrae/pweb/imager/raw"
 	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
+// Formats lists the formats that can be written.
+// WebP images are written lossless, since there is no pure Go lossy WebP encoder.
+var Formats = []imager.Format{imager.JPEG, imager.WebP}
+
 type disImage struct {
 	img image.Image
 }
 
+// NewDisImage reads and decodes an image using the Go image decoders (JPEG, PNG, TIFF and WebP).
+// For camera raw files, the largest embedded JPEG preview is decoded.
 func NewDisImage(src string) (imager.Image, error) {
+	if raw.IsRaw(src) {
+		b, err := raw.Preview(src)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return &disImage{img: img}, nil
+	}
+	if imager.IsHEIF(src) {
+		return nil, errors.New("HEIC/HEIF images require the vips image processor")
+	}
 	f, err := os.Open(src)
 	if err != nil {
 		return nil, err
@@ -26,6 +56,26 @@ func NewDisImage(src string) (imager.Image, error) {
 	return &disImage{img: img}, nil
 }
 
+// NewDisReduced returns the image decoded from the EXIF thumbnail embedded in the file,
+// if the thumbnail is at least the minimum size and matches the aspect ratio of the
+// full size image. The Go JPEG decoder cannot decode at a reduced scale, so otherwise
+// ErrNoPreview is returned. The method used to read the image is also returned.
+func NewDisReduced(src string, full, min imager.Size) (imager.Image, string, error) {
+	b, err := goexif.Thumbnail(src)
+	if err != nil {
+		return nil, "", imager.ErrNoPreview
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	r := img.Bounds()
+	if !imager.PreviewFits(r.Dx(), r.Dy(), full, min) {
+		return nil, "", fmt.Errorf("%w (EXIF thumbnail is %d x %d)", imager.ErrNoPreview, r.Dx(), r.Dy())
+	}
+	return &disImage{img: img}, fmt.Sprintf("EXIF thumbnail (%d x %d)", r.Dx(), r.Dy()), nil
+}
+
 func (d *disImage) Width() int {
 	return d.img.Bounds().Max.X
 }
@@ -46,22 +96,43 @@ func (d *disImage) Rotate(deg imager.RotateDegrees) error {
 	return nil
 }
 
-func (d *disImage) Write(destFile string, mtime time.Time, w, h, q int) error {
-	// scale it down to fit within the width & height
-	xr := float64(w) / float64(d.Width())
-	yr := float64(h) / float64(d.Height())
-	var img image.Image
-	if xr < 1 || yr < 1 {
-		// Image is larger than requested size, so scale it down
-		if xr < yr {
-			img = imaging.Resize(d.img, w, 0, imaging.Lanczos)
-		} else {
-			img = imaging.Resize(d.img, 0, h, imaging.Lanczos)
-		}
-	} else {
-		img = d.img
+func (d *disImage) Flip(dir imager.FlipDirection) error {
+	switch dir {
+	case imager.FlipHorizontal:
+		d.img = imaging.FlipH(d.img)
+	case imager.FlipVertical:
+		d.img = imaging.FlipV(d.img)
+	case imager.FlipTranspose:
+		d.img = imaging.Transpose(d.img)
+	case imager.FlipTransverse:
+		d.img = imaging.Transverse(d.img)
+	}
+	return nil
+}
+
+// Scale returns a copy of the image resampled to the width and height.
+func (d *disImage) Scale(w, h int) (imager.Image, error) {
+	return &disImage{img: imaging.Resize(d.img, w, h, imaging.Lanczos)}, nil
+}
+
+func (d *disImage) Write(destFile string, mtime time.Time, q int, format imager.Format) error {
+	f, err := os.Create(destFile)
+	if err != nil {
+		return err
 	}
-	if err := imaging.Save(img, destFile, imaging.JPEGQuality(q)); err != nil {
+	switch format {
+	case imager.JPEG:
+		err = imaging.Encode(f, d.img, imaging.JPEG, imaging.JPEGQuality(q))
+	case imager.WebP:
+		err = nativewebp.Encode(f, d.img, nil)
+	default:
+		err = imager.ErrUnsupportedFormat
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(destFile)
 		return err
 	}
 	return os.Chtimes(destFile, mtime, mtime)
@@ -1,9 +1,14 @@
 package imager
 
 import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
+// RotateDegrees is the angle of a counter-clockwise rotation.
 type RotateDegrees int
 
 const (
@@ -12,10 +17,167 @@ const (
 	Rotate270
 )
 
+// FlipDirection selects the axis that an image is mirrored across.
+type FlipDirection int
+
+const (
+	FlipHorizontal FlipDirection = iota // Mirror left to right
+	FlipVertical                        // Mirror top to bottom
+	FlipTranspose                       // Mirror across the top-left to bottom-right diagonal
+	FlipTransverse                      // Mirror across the top-right to bottom-left diagonal
+)
+
+// Format is the file format used when writing an image.
+type Format int
+
+const (
+	JPEG Format = iota
+	WebP
+	AVIF
+)
+
+// ErrUnsupportedFormat is returned when the image processor cannot write the format.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+var formatNames = []string{"jpeg", "webp", "avif"}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// ParseFormat returns the format matching the name.
+func ParseFormat(name string) (Format, bool) {
+	for i, n := range formatNames {
+		if n == name {
+			return Format(i), true
+		}
+	}
+	return JPEG, false
+}
+
+// IsHEIF returns true if the file is a HEIC or HEIF image.
+func IsHEIF(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".heic" || ext == ".heif"
+}
+
+// IsWebImage returns true if the file is in a format that can be displayed by browsers
+// without conversion (the scaled images of other formats are written as JPEG files).
+func IsWebImage(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
 // Image defines the interface to an image processor for an image.
 type Image interface {
 	Width() int
 	Height() int
 	Rotate(degrees RotateDegrees) error
-	Write(dest string, mtime time.Time, width, height, quality int) error
+	Flip(direction FlipDirection) error
+	// Scale returns a new image scaled to the width and height.
+	Scale(width, height int) (Image, error)
+	// Write encodes the image in the format, and writes it to the file
+	// with the modified time set.
+	Write(dest string, mtime time.Time, quality int, format Format) error
+}
+
+// Close releases any resources held by the image, if the image processor
+// holds resources outside the Go heap (e.g libvips).
+func Close(img Image) {
+	if c, ok := img.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// Size is the maximum width and height of a scaled image.
+type Size struct {
+	Width, Height int
+}
+
+// MinCascadeRatio is the minimum ratio of the width of an image to the width of an image
+// scaled from it when cascading, so that each scaled image is resampled from
+// an image with sufficient detail.
+const MinCascadeRatio = 2.0
+
+// Fit returns the dimensions of an image of width w and height h when scaled down
+// to fit within the size, preserving the aspect ratio. Images are never scaled up.
+func Fit(w, h int, sz Size) (int, int) {
+	xr := float64(sz.Width) / float64(w)
+	yr := float64(sz.Height) / float64(h)
+	if xr >= 1 && yr >= 1 {
+		return w, h
+	}
+	if xr < yr {
+		return sz.Width, max(1, int(float64(h)*xr+0.5))
+	}
+	return max(1, int(float64(w)*yr+0.5)), sz.Height
+}
+
+// Cascade scales the image to each of the sizes from a single decoded image, calling fn with
+// the index of the size and the scaled image. The sizes are processed largest first, and each
+// image is scaled from the smallest image already scaled that is at least MinCascadeRatio
+// times larger, or from the original image, rather than always from the original image.
+// The scaled images are closed before returning.
+func Cascade(img Image, sizes []Size, fn func(i int, scaled Image) error) error {
+	type scaled struct {
+		i    int
+		w, h int
+	}
+	var order []scaled
+	for i, sz := range sizes {
+		w, h := Fit(img.Width(), img.Height(), sz)
+		order = append(order, scaled{i: i, w: w, h: h})
+	}
+	slices.SortStableFunc(order, func(a, b scaled) int {
+		return b.w*b.h - a.w*a.h
+	})
+	// Images that have been scaled, largest first.
+	var done []Image
+	defer func() {
+		for _, d := range done {
+			Close(d)
+		}
+	}()
+	for _, s := range order {
+		if s.w == img.Width() && s.h == img.Height() {
+			if err := fn(s.i, img); err != nil {
+				return err
+			}
+			continue
+		}
+		src := img
+		for _, d := range done {
+			if float64(d.Width()) >= MinCascadeRatio*float64(s.w) && float64(d.Height()) >= MinCascadeRatio*float64(s.h) {
+				src = d
+			}
+		}
+		out, err := src.Scale(s.w, s.h)
+		if err != nil {
+			return err
+		}
+		done = append(done, out)
+		if err := fn(s.i, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrNoPreview is returned when an image cannot be read at a reduced resolution.
+var ErrNoPreview = errors.New("no suitable preview")
+
+// PreviewFits returns true if a preview image of width w and height h is at least the
+// minimum size, and has the same aspect ratio as the full size image (embedded
+// previews are sometimes padded to a fixed aspect ratio).
+func PreviewFits(w, h int, full, min Size) bool {
+	if w < min.Width || h < min.Height {
+		return false
+	}
+	// Allow for rounding of the preview dimensions.
+	d := w*full.Height - h*full.Width
+	if d < 0 {
+		d = -d
+	}
+	return d*100 <= h*full.Width
 }
@@ -0,0 +1,127 @@
+package imager_test
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aamcrae/pweb/imager"
+	"github.com/aamcrae/pweb/imager/dis"
+	"golang.org/x/image/webp"
+)
+
+// Sizes of the gallery images (see resizebench).
+var sizes = []imager.Size{{1600, 1200}, {1024, 768}, {640, 480}, {160, 160}}
+
+// openPhotos decodes up to n of the example photos.
+func openPhotos(tb testing.TB, n int) []imager.Image {
+	files, err := filepath.Glob("../example/photos/*.jpg")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(files) == 0 {
+		tb.Skip("no example photos")
+	}
+	var imgs []imager.Image
+	for _, f := range files[:min(n, len(files))] {
+		img, err := dis.NewDisImage(f)
+		if err != nil {
+			tb.Fatalf("%s: %v", f, err)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+// pixels returns the decoded pixels of the image, written as a lossless WebP file.
+func pixels(t *testing.T, img imager.Image) image.Image {
+	f := filepath.Join(t.TempDir(), "img.webp")
+	if err := img.Write(f, time.Now(), 100, imager.WebP); err != nil {
+		t.Fatal(err)
+	}
+	r, err := os.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	p, err := webp.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// meanDiff returns the mean absolute difference of the colour channels of the images, from 0 to 255.
+func meanDiff(a, b image.Image) float64 {
+	var sum, n float64
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ar, ag, ab, _ := a.At(x, y).RGBA()
+			br, bg, bb, _ := b.At(x, y).RGBA()
+			for _, d := range [][2]uint32{{ar, br}, {ag, bg}, {ab, bb}} {
+				sum += float64(max(d[0], d[1])-min(d[0], d[1])) / 257
+				n++
+			}
+		}
+	}
+	return sum / n
+}
+
+// TestCascade checks that the images scaled by Cascade have the same size as, and
+// are close to, the images scaled directly from the original.
+func TestCascade(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	for _, img := range openPhotos(t, 1) {
+		err := imager.Cascade(img, sizes, func(i int, scaled imager.Image) error {
+			w, h := imager.Fit(img.Width(), img.Height(), sizes[i])
+			if scaled.Width() != w || scaled.Height() != h {
+				t.Fatalf("size %v: got %d x %d, want %d x %d", sizes[i], scaled.Width(), scaled.Height(), w, h)
+			}
+			direct, err := img.Scale(w, h)
+			if err != nil {
+				return err
+			}
+			if d := meanDiff(pixels(t, scaled), pixels(t, direct)); d > 1 {
+				t.Errorf("size %v: mean difference from direct scaling is %.2f", sizes[i], d)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDirect(b *testing.B) {
+	imgs := openPhotos(b, 4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img := imgs[i%len(imgs)]
+		for _, sz := range sizes {
+			w, h := imager.Fit(img.Width(), img.Height(), sz)
+			scaled, err := img.Scale(w, h)
+			if err != nil {
+				b.Fatal(err)
+			}
+			imager.Close(scaled)
+		}
+	}
+}
+
+func BenchmarkCascade(b *testing.B) {
+	imgs := openPhotos(b, 4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := imager.Cascade(imgs[i%len(imgs)], sizes, func(int, imager.Image) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package raw extracts the embedded JPEG previews from TIFF based
+// camera raw files (e.g CR2, NEF, DNG, ARW), so that raw files can be
+// published without a raw converter.
+package raw
+
+import (
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Extensions lists the filename extensions of the supported raw files.
+var Extensions = []string{".cr2", ".nef", ".nrw", ".dng", ".arw", ".pef", ".srw"}
+
+// ErrNoPreview is returned when a raw file does not contain a JPEG preview.
+var ErrNoPreview = errors.New("no JPEG preview in raw file")
+
+// TIFF tags used to locate the previews.
+const (
+	tagCompression    = 0x103
+	tagStripOffsets   = 0x111
+	tagStripByteCount = 0x117
+	tagSubIFDs        = 0x14A
+	tagJpegOffset     = 0x201
+	tagJpegLength     = 0x202
+)
+
+// Limit on the number of IFDs read, in case of loops.
+const maxIFDs = 64
+
+// IsRaw returns true if the file is one of the supported raw files.
+func IsRaw(file string) bool {
+	return slices.Contains(Extensions, strings.ToLower(filepath.Ext(file)))
+}
+
+// preview is the location of an embedded JPEG image.
+type preview struct {
+	offset, length int64
+}
+
+// rawFile is a TIFF based raw file.
+type rawFile struct {
+	r        io.ReaderAt
+	size     int64
+	order    binary.ByteOrder
+	visited  map[uint32]bool
+	previews []preview
+}
+
+// Preview returns the largest JPEG preview image embedded in the raw file.
+func Preview(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	rf := &rawFile{r: f, size: st.Size(), visited: make(map[uint32]bool)}
+	var hdr [8]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	switch string(hdr[:4]) {
+	case "II*\x00":
+		rf.order = binary.LittleEndian
+	case "MM\x00*":
+		rf.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF based raw file")
+	}
+	rf.readIFDs(rf.order.Uint32(hdr[4:]))
+	// Select the largest preview that can be decoded (raw image data may also
+	// be stored as a lossless JPEG, which is not supported by the decoder).
+	var best preview
+	var bestPixels int
+	for _, p := range rf.previews {
+		c, err := jpeg.DecodeConfig(io.NewSectionReader(f, p.offset, p.length))
+		if err == nil && c.Width*c.Height > bestPixels {
+			best, bestPixels = p, c.Width*c.Height
+		}
+	}
+	if bestPixels == 0 {
+		return nil, ErrNoPreview
+	}
+	b := make([]byte, best.length)
+	if _, err := f.ReadAt(b, best.offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readIFDs reads the chain of IFDs starting at offset, and any sub-IFDs,
+// recording the location of the JPEG images found.
+func (rf *rawFile) readIFDs(offset uint32) {
+	for offset != 0 && !rf.visited[offset] && len(rf.visited) < maxIFDs {
+		rf.visited[offset] = true
+		var nb [2]byte
+		if _, err := rf.r.ReadAt(nb[:], int64(offset)); err != nil {
+			return
+		}
+		n := int(rf.order.Uint16(nb[:]))
+		entries := make([]byte, n*12+4)
+		if _, err := rf.r.ReadAt(entries, int64(offset)+2); err != nil {
+			return
+		}
+		var compression, stripOffset, stripLength, jpegOffset, jpegLength uint32
+		var subIFDs []uint32
+		for i := 0; i < n; i++ {
+			e := entries[i*12 : i*12+12]
+			tag := rf.order.Uint16(e)
+			typ := rf.order.Uint16(e[2:])
+			count := rf.order.Uint32(e[4:])
+			switch tag {
+			case tagCompression:
+				compression = rf.value(typ, e)
+			case tagStripOffsets:
+				if count == 1 {
+					stripOffset = rf.value(typ, e)
+				}
+			case tagStripByteCount:
+				if count == 1 {
+					stripLength = rf.value(typ, e)
+				}
+			case tagJpegOffset:
+				jpegOffset = rf.value(typ, e)
+			case tagJpegLength:
+				jpegLength = rf.value(typ, e)
+			case tagSubIFDs:
+				subIFDs = rf.offsets(count, e)
+			}
+		}
+		if jpegOffset != 0 && jpegLength != 0 {
+			rf.add(jpegOffset, jpegLength)
+		}
+		// Compression of 6 (old style JPEG) or 7 (JPEG).
+		if (compression == 6 || compression == 7) && stripOffset != 0 && stripLength != 0 {
+			rf.add(stripOffset, stripLength)
+		}
+		for _, s := range subIFDs {
+			rf.readIFDs(s)
+		}
+		offset = rf.order.Uint32(entries[n*12:])
+	}
+}
+
+// value returns the value of a SHORT or LONG entry with a count of 1.
+func (rf *rawFile) value(typ uint16, e []byte) uint32 {
+	if typ == 3 {
+		return uint32(rf.order.Uint16(e[8:]))
+	}
+	return rf.order.Uint32(e[8:])
+}
+
+// offsets returns the list of IFD offsets in the entry.
+func (rf *rawFile) offsets(count uint32, e []byte) []uint32 {
+	if count == 1 {
+		return []uint32{rf.order.Uint32(e[8:])}
+	}
+	if count > maxIFDs {
+		return nil
+	}
+	b := make([]byte, count*4)
+	if _, err := rf.r.ReadAt(b, int64(rf.order.Uint32(e[8:]))); err != nil {
+		return nil
+	}
+	var offs []uint32
+	for i := range count {
+		offs = append(offs, rf.order.Uint32(b[i*4:]))
+	}
+	return offs
+}
+
+// add records the location of a JPEG image, if it is within the file.
+func (rf *rawFile) add(offset, length uint32) {
+	if int64(offset)+int64(length) <= rf.size {
+		rf.previews = append(rf.previews, preview{offset: int64(offset), length: int64(length)})
+	}
+}
@@ -1,10 +1,13 @@
 package vips
 
 import (
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/aamcrae/pweb/exif/goexif"
 	"github.com/aamcrae/pweb/imager"
+	"github.com/aamcrae/pweb/imager/raw"
 	"github.com/davidbyttow/govips/v2/vips"
 )
 
@@ -17,6 +20,9 @@ type vipsImage struct {
 	img *vips.ImageRef
 }
 
+// Formats lists the formats that can be written.
+var Formats = []imager.Format{imager.JPEG, imager.WebP, imager.AVIF}
+
 func VipsInit() {
 	// Make vips less noisy.
 	vips.LoggingSettings(nil, vips.LogLevelError)
@@ -24,8 +30,20 @@ func VipsInit() {
 }
 
 // NewVipsImage returns an image loaded and managed by the
-// cgo bindings to libvips.
+// cgo bindings to libvips. libvips reads JPEG, PNG, TIFF and HEIC images (HEIC requires
+// libvips to be built with libheif). For camera raw files, the largest embedded JPEG preview is loaded.
 func NewVipsImage(src string) (imager.Image, error) {
+	if raw.IsRaw(src) {
+		b, err := raw.Preview(src)
+		if err != nil {
+			return nil, err
+		}
+		vimg, err := vips.NewImageFromBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return &vipsImage{img: vimg}, nil
+	}
 	vimg, err := vips.NewImageFromFile(src)
 	if err != nil {
 		return nil, err
@@ -33,6 +51,40 @@ func NewVipsImage(src string) (imager.Image, error) {
 	return &vipsImage{img: vimg}, nil
 }
 
+// NewVipsReduced returns the image read at a reduced resolution of at least the
+// minimum size, using the EXIF thumbnail embedded in the file if it is large enough,
+// or otherwise decoding a JPEG image at 1/2, 1/4 or 1/8 scale. ErrNoPreview is returned
+// if neither can be used. The method used to read the image is also returned.
+func NewVipsReduced(src string, full, min imager.Size) (imager.Image, string, error) {
+	if b, err := goexif.Thumbnail(src); err == nil {
+		if vimg, err := vips.NewImageFromBuffer(b); err == nil {
+			if imager.PreviewFits(vimg.Width(), vimg.Height(), full, min) {
+				return &vipsImage{img: vimg}, fmt.Sprintf("EXIF thumbnail (%d x %d)", vimg.Width(), vimg.Height()), nil
+			}
+			vimg.Close()
+		}
+	}
+	buf, err := os.ReadFile(src)
+	if err != nil {
+		return nil, "", err
+	}
+	if vips.DetermineImageType(buf) != vips.ImageTypeJPEG {
+		return nil, "", imager.ErrNoPreview
+	}
+	for _, shrink := range []int{8, 4, 2} {
+		if full.Width/shrink >= min.Width && full.Height/shrink >= min.Height {
+			p := vips.NewImportParams()
+			p.JpegShrinkFactor.Set(shrink)
+			vimg, err := vips.LoadImageFromBuffer(buf, p)
+			if err != nil {
+				return nil, "", err
+			}
+			return &vipsImage{img: vimg}, fmt.Sprintf("JPEG decoded at 1/%d scale", shrink), nil
+		}
+	}
+	return nil, "", imager.ErrNoPreview
+}
+
 func (v *vipsImage) Width() int {
 	return v.img.Width()
 }
@@ -41,41 +93,81 @@ func (v *vipsImage) Height() int {
 	return v.img.Height()
 }
 
+// Rotate rotates the image counter-clockwise (vips angles are clockwise).
 func (v *vipsImage) Rotate(deg imager.RotateDegrees) error {
 	switch deg {
 	case imager.Rotate90:
-		v.img.Rotate(vips.Angle90)
+		return v.img.Rotate(vips.Angle270)
 	case imager.Rotate180:
-		v.img.Rotate(vips.Angle180)
+		return v.img.Rotate(vips.Angle180)
 	case imager.Rotate270:
-		v.img.Rotate(vips.Angle270)
+		return v.img.Rotate(vips.Angle90)
 	}
 	return nil
 }
 
-func (v *vipsImage) Write(destFile string, mtime time.Time, w, h, q int) error {
-	vimg, err := v.img.Copy()
-	if err != nil {
-		return err
-	}
-	// scale it down to fit within the width & height
-	xr := float64(w) / float64(vimg.Width())
-	yr := float64(h) / float64(vimg.Height())
-	if xr < 1 || yr < 1 {
-		// Image is larger than requested size, so scale it down
-		var err error
-		if xr < yr {
-			err = vimg.Resize(xr, vips.KernelAuto)
-		} else {
-			err = vimg.Resize(yr, vips.KernelAuto)
+func (v *vipsImage) Flip(dir imager.FlipDirection) error {
+	switch dir {
+	case imager.FlipHorizontal:
+		return v.img.Flip(vips.DirectionHorizontal)
+	case imager.FlipVertical:
+		return v.img.Flip(vips.DirectionVertical)
+	case imager.FlipTranspose:
+		// Rotate 90 degrees clockwise, then mirror left to right.
+		if err := v.img.Rotate(vips.Angle90); err != nil {
+			return err
 		}
-		if err != nil {
+		return v.img.Flip(vips.DirectionHorizontal)
+	case imager.FlipTransverse:
+		// Rotate 90 degrees clockwise, then mirror top to bottom.
+		if err := v.img.Rotate(vips.Angle90); err != nil {
 			return err
 		}
+		return v.img.Flip(vips.DirectionVertical)
+	}
+	return nil
+}
+
+// Scale returns a copy of the image resampled to the width and height.
+func (v *vipsImage) Scale(w, h int) (imager.Image, error) {
+	vimg, err := v.img.Copy()
+	if err != nil {
+		return nil, err
+	}
+	hr := float64(w) / float64(vimg.Width())
+	vr := float64(h) / float64(vimg.Height())
+	if err := vimg.ResizeWithVScale(hr, vr, vips.KernelAuto); err != nil {
+		vimg.Close()
+		return nil, err
+	}
+	return &vipsImage{img: vimg}, nil
+}
+
+// Close frees the image held by libvips.
+func (v *vipsImage) Close() {
+	v.img.Close()
+}
+
+func (v *vipsImage) Write(destFile string, mtime time.Time, q int, format imager.Format) error {
+	vimg := v.img
+	var err error
+	var b []byte
+	switch format {
+	case imager.JPEG:
+		jp := vips.NewJpegExportParams()
+		jp.Quality = q
+		b, _, err = vimg.ExportJpeg(jp)
+	case imager.WebP:
+		wp := vips.NewWebpExportParams()
+		wp.Quality = q
+		b, _, err = vimg.ExportWebp(wp)
+	case imager.AVIF:
+		ap := vips.NewAvifExportParams()
+		ap.Quality = q
+		b, _, err = vimg.ExportAvif(ap)
+	default:
+		err = imager.ErrUnsupportedFormat
 	}
-	jp := vips.NewJpegExportParams()
-	jp.Quality = q
-	b, _, err := vimg.ExportJpeg(jp)
 	if err != nil {
 		return err
 	}
@@ -1,43 +1,23 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"path"
+	"os/signal"
+	"path/filepath"
 	"runtime/pprof"
-	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aamcrae/pweb/shared"
+	"github.com/aamcrae/pweb/builder"
 )
 
-const (
-	previewWidth  = 320
-	previewHeight = 240
-)
-
-const (
-	DL_NONE = iota
-	DL_SYMLINK
-	DL_STATIC
-)
-
-const (
-	SORT_NONE = iota
-	SORT_NAME
-	SORT_DATE
-)
-
-var thumbWidth int = 160
-var thumbHeight int = 160
-var imageWidth int = 1500
-var imageHeight int = 1200
-
 const configDefault = ".web"
 
 var verbose = flag.Bool("verbose", false, "Verbose output")
@@ -45,24 +25,22 @@ var force = flag.Bool("force", false, "Force rebuild")
 var baseDir = flag.String("base", "/var/www/html/photos", "Base directory of web pages")
 var assets = flag.String("assets", "/usr/share/pweb", "Source directory of web assets")
 var imagerName = flag.String("imager", "dis", "Select the image handler")
-var watchdog = flag.Int("watchdog", 120, "Timeout in seconds of watchdog")
+var exifName = flag.String("exif", "exiv2", "Select the metadata reader (exiv2, goexif)")
+var timeout = flag.Int("timeout", 600, "Timeout in seconds for processing each image (0 for no timeout)")
+var maxMemory = flag.String("max-memory", "", "Limit of the memory used for processing images, e.g 4G or 512M (default no limit)")
+var workers = flag.Int("workers", 0, "Maximum number of images processed concurrently (0 for the number of CPUs)")
 var cpuprofile = flag.String("cpuprofile", "", "Write CPU profile to file")
-
-// rScaleMap maps a selected rating to photo ratings that will be accepted
-// e.g a rating of '3' will select photos with a rating of '3', '4' and '5'.
-var rScaleMap = map[string][]string{
-	"0": {"0", "1", "2", "3", "4", "5"},
-	"1": {"1", "2", "3", "4", "5"},
-	"2": {"2", "3", "4", "5"},
-	"3": {"3", "4", "5"},
-	"4": {"4", "5"},
-	"5": {"5"},
-}
+var noCache = flag.Bool("no-cache", false, "Do not use the EXIF cache")
+var fastThumbs = flag.Bool("fast-thumbs", false, "Generate thumbnails from embedded previews or reduced scale decoding where possible")
+var onError = flag.String("on-error", "abort", "Action when an image cannot be processed (abort, skip)")
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
 	log.SetFlags(0)
+	if err := readUserConfig(); err != nil {
+		log.Fatalf("%v", err)
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -72,395 +50,184 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 	args := flag.Args()
-	var conf Config
-	var err error
+	if len(args) == 1 && args[0] == "cache-prune" {
+		if err := pruneCache(); err != nil {
+			log.Fatalf("cache-prune: %v", err)
+		}
+		return
+	}
+	if len(args) > 1 && args[0] == "manifest" && args[1] == "export" {
+		if err := exportManifest(args[2:]); err != nil {
+			log.Fatalf("manifest export: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if err := showConfig(args[1:]); err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		return
+	}
+	var confFile string
 	if len(args) == 0 {
-		conf, err = ReadConfig(configDefault)
+		confFile = configDefault
 	} else if len(args) == 1 {
-		conf, err = ReadConfig(args[0])
+		confFile = args[0]
 	} else {
 		flag.Usage()
 		log.Fatalf("Exiting...")
 	}
-	if err != nil {
-		log.Fatalf(err.Error())
-	}
-	d, ok := conf[C_DIR]
-	if !ok {
-		log.Fatalf("%s: missing 'dir' config", args[0])
-	}
-	dir := d[0]
-	destDir := path.Join(*baseDir, dir)
-	if *verbose {
-		fmt.Printf("Directory set to %s\n", destDir)
-	}
-	var files, fl []string
-	if incList, ok := conf[C_INCLUDE]; !ok {
-		fl, err = globFiles([]string{"*.jpg", "*.jpeg"})
-	} else {
-		fl, err = globFiles(incList)
-	}
+	conf, err := builder.LoadConfig(confFile)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
-	files = append(files, fl...)
-	if *verbose {
-		fmt.Printf("Include list: %v\n", files)
-	}
-	if excArg, ok := conf[C_EXCLUDE]; ok {
-		fl, err := globFiles(excArg)
-		if err != nil {
-			log.Fatalf("%s: %v", excArg, err)
-		}
-		for _, ex := range fl {
-			if ind, ok := find(files, ex); ok {
-				files = append(files[:ind], files[ind+1:]...)
-			} else {
-				log.Printf("Cannot find %s in file list, ignored", ex)
-			}
-		}
-	}
-	if afterList, ok := conf[C_AFTER]; ok {
-		files, err = insert(files, afterList, false)
-		if err != nil {
-			log.Fatalf("after: %v", err)
-		}
-	}
-	if beforeList, ok := conf[C_BEFORE]; ok {
-		files, err = insert(files, beforeList, true)
-		if err != nil {
-			log.Fatalf("before: %v", err)
-		}
-	}
-	if *verbose {
-		fmt.Printf("Before ratings and sorting: %v\n", files)
-	}
-	srcDir, err := os.Getwd()
+	opts, err := buildOptions()
 	if err != nil {
-		log.Fatalf("Getwd %v", err)
-	}
-	// If a rating config is set, build a map of
-	// allowed ratings (either as a scale or as selected
-	// ratings)
-	ratingMap := make(map[string]struct{})
-	ratings, useRating := conf[C_RATING]
-	sel, useSelect := conf[C_SELECT]
-	if useRating && useSelect {
-		log.Fatalf("Cannot use both select and rating")
-	}
-	if useRating {
-		buildRatings(ratings, ratingMap, true)
-	}
-	if useSelect {
-		buildRatings(sel, ratingMap, false)
-	}
-	// If a thumbnail size is set, use it.
-	thsz, ok := conf[C_THUMB]
-	if ok {
-		var sz int
-		n, err := fmt.Sscanf(thsz[0], "%d", &sz)
-		if err != nil {
-			log.Fatalf("Bad thumbnail size (%s)", err)
-		}
-		if n != 1 {
-			log.Fatalf("Unknown thumbnail size (%s)", thsz)
-		}
-		thumbWidth = sz
-		thumbHeight = sz
-	}
-	// Build map of captions
-	capt := make(map[string]string)
-	cl, ok := conf[C_CAPTION]
-	if ok {
-		buildCaptions(cl, capt)
-	}
-	// If configured, sort by date or name. Otherwise leave pictures in the include order.
-	sortKey := SORT_NONE
-	if skey, ok := conf[C_SORT]; ok {
-		switch skey[0] {
-		case "date":
-			sortKey = SORT_DATE
-		case "name":
-			sortKey = SORT_NAME
-		}
-	}
-	exifRequired := useSelect || useRating || (sortKey == SORT_DATE) || len(capt) > 0
-	picts := readPicts(files, srcDir, destDir, exifRequired)
-	if useSelect || useRating {
-		picts = filterPicts(picts, ratingMap)
-	}
-	if len(capt) > 0 {
-		addCaptions(picts, capt)
+		log.Fatalf("%v", err)
 	}
-	switch sortKey {
-	case SORT_DATE:
-		slices.SortStableFunc(picts, func(a, b *Pict) int {
-			return a.MustExif().ts.Compare(b.MustExif().ts)
-		})
-	case SORT_NAME:
-		slices.SortStableFunc(picts, func(a, b *Pict) int {
-			return strings.Compare(a.baseName, b.baseName)
-		})
+	// Cancel the build on an interrupt, so that no partially written images are left.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := builder.Build(ctx, conf, opts)
+	stop()
+	if cerr := opts.Cache.Save(); cerr != nil {
+		log.Printf("EXIF cache: %v", cerr)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Fatalf("Interrupted")
+	} else if err != nil {
+		log.Fatalf("%v", err)
 	}
-	if *verbose {
-		fmt.Printf("Final list:")
-		for _, p := range picts {
-			fmt.Printf(" %s", p.srcFile)
+	if len(report.Skipped) != 0 {
+		log.Printf("%d image(s) skipped:", len(report.Skipped))
+		for _, e := range report.Skipped {
+			log.Printf("  %v", e)
 		}
-		fmt.Printf("\n")
+		os.Exit(1)
 	}
-	// If force is on, delete the entire destination directory
-	if *force {
-		if err := os.RemoveAll(destDir); err != nil {
-			log.Fatalf("%s: %v", destDir, err)
-		}
-	} else {
-		// Remove any images no longer wanted
-		removeUnwanted(destDir, picts)
+}
+
+// buildOptions returns the build options selected by the flags.
+func buildOptions() (builder.Options, error) {
+	opts := builder.Options{
+		BaseDir:    *baseDir,
+		Assets:     *assets,
+		Force:      *force,
+		Verbose:    *verbose,
+		Progress:   true,
+		Timeout:    time.Second * time.Duration(*timeout),
+		Workers:    *workers,
+		FastThumbs: *fastThumbs,
 	}
-	if _, ok := conf[C_LARGE]; ok {
-		imageWidth = 1800
-		imageHeight = 1500
+	var err error
+	if opts.MaxMemory, err = parseSize(*maxMemory); err != nil {
+		return opts, fmt.Errorf("max-memory: %v", err)
 	}
-	var title string
-	if t, ok := conf[C_TITLE]; !ok {
-		title = "Photo album"
-	} else {
-		title = t[0]
+	switch *onError {
+	case "abort":
+		opts.OnError = builder.ONERROR_ABORT
+	case "skip":
+		opts.OnError = builder.ONERROR_SKIP
+	default:
+		return opts, fmt.Errorf("%s: Unknown error action", *onError)
 	}
-	up, upConfigured := conf[C_UP]
-	_, reverse := conf[C_REVERSE]
-	if upConfigured {
-		if err := UpdateAlbum(up[0], *baseDir, dir, title, reverse); err != nil {
-			log.Fatalf("update album: %v", err)
-		}
+	if opts.Imager, err = builder.SelectImager(*imagerName); err != nil {
+		return opts, err
 	}
-	download := DL_NONE
-	if dl_arg, ok := conf[C_DOWNLOAD]; ok {
-		switch dl_arg[0] {
-		case "", "symlink":
-			download = DL_SYMLINK
-		case "static":
-			download = DL_STATIC
-		}
+	if opts.Metadata, err = builder.SelectMetadata(*exifName); err != nil {
+		return opts, err
 	}
-	_, nozip := conf[C_NOZIP]
-	// Ensure base page, thumbnail, preview and (optionally) download directories exist.
-	makeDirs(destDir, path.Join(destDir, "t"), path.Join(destDir, "p"))
-	dlDir := path.Join(destDir, "d")
-	if download == DL_NONE {
-		// Remove any download directory
-		os.RemoveAll(dlDir)
-	} else {
-		makeDirs(dlDir)
-		// If there is a .htaccess file required, copy it.
-		if err := cpMaybe(path.Join(*assets, "download-htaccess"), path.Join(dlDir, ".htaccess")); err != nil {
-			log.Fatalf("Write htaccess %v", err)
+	if !*noCache {
+		if opts.Cache, err = builder.OpenCache(); err != nil {
+			log.Printf("EXIF cache disabled: %v", err)
 		}
 	}
-	var g shared.Gallery
-	// Preload gallery from template (to set copyright etc.)
-	readMeta(path.Join(*assets, shared.TemplateGalleryFileMeta), &g)
-	g.Title = title
-	if download != DL_NONE && !nozip {
-		g.Download = path.Join("d", "photos.zip")
-	}
-	if upConfigured {
-		g.Back = up[0]
-	}
-	g.Thumb.Width = thumbWidth
-	g.Thumb.Height = thumbHeight
-	g.Preview.Width = previewWidth
-	g.Preview.Height = previewHeight
-	g.Image.Width = imageWidth
-	g.Image.Height = imageHeight
-	imgHandler := selectImager(*imagerName)
-	// Now generate the scaled images that will appear on the web site.
-	resizePhotos(imgHandler, picts, download)
-	// Add the images to the gallery - this is done after the
-	// resize in order to capture the original resolution dimensions, which is
-	// only known after the image is processed.
-	for _, p := range picts {
-		p.AddToGallery(&g, download)
-	}
-	// Write the gallery file
-	gFile := path.Join(destDir, shared.GalleryFileMeta)
-	if err := writeMeta(gFile, &g); err != nil {
-		log.Fatalf("%s: %v", gFile, err)
-	}
-	if download != DL_NONE && !nozip {
-		updateZip(path.Join(destDir, "d"))
-	}
-	// Conditionally copy the main index.html file.
-	if err := cpFile(path.Join(*assets, "index.html"), path.Join(destDir, "index.html")); err != nil {
-		log.Fatalf("index.html: Update %v", err)
-	}
+	return opts, nil
 }
 
-// readPicts will create a photo object and optionally read the EXIF (if the EXIF
-// data is required for further processing)
-func readPicts(files []string, srcDir, destDir string, exifRequired bool) []*Pict {
-	// Create a worker pool to read the EXIF data
-	var unratedPicts []*Pict
-	pWork := NewWorker(time.Second*time.Duration(*watchdog), "Reading ", len(files))
-	for _, f := range files {
-		p, err := NewPict(f, srcDir, destDir)
-		if err != nil {
-			log.Fatalf("%s: %v", f, err)
-		}
-		unratedPicts = append(unratedPicts, p)
-		// Read the EXIF if required
-		if exifRequired {
-			pWork.Run(func() {
-				_ = p.MustExif()
-			})
-		}
+// exportManifest implements the manifest export command, which writes a manifest
+// file of the gallery's final picture list, with the titles, captions and ratings.
+// The manifest is written as TSV if the output file has a .tsv extension, otherwise as CSV.
+func exportManifest(args []string) error {
+	fs := flag.NewFlagSet("manifest export", flag.ExitOnError)
+	out := fs.String("out", "", "Output file (default standard output)")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: %s [flags] manifest export [--out file] [config-file]", os.Args[0])
+	}
+	confFile := configDefault
+	if fs.NArg() == 1 {
+		confFile = fs.Arg(0)
+	}
+	conf, err := builder.LoadConfig(confFile)
+	if err != nil {
+		return err
 	}
-	pWork.Wait()
-	return unratedPicts
-}
-
-func filterPicts(inPicts []*Pict, ratingMap map[string]struct{}) []*Pict {
-	var outPicts []*Pict
-	for _, p := range inPicts {
-		rating := p.MustExif().rating
-		_, ok := ratingMap[rating]
-		if !ok {
-			if *verbose {
-				fmt.Printf("%s: Skipping due to rating (%s)\n", p.srcFile, rating)
-			}
-			continue
-		}
-		outPicts = append(outPicts, p)
+	opts, err := buildOptions()
+	if err != nil {
+		return err
 	}
-	return outPicts
-}
-
-func addCaptions(pl []*Pict, capt map[string]string) {
-	for _, p := range pl {
-		if c, ok := capt[p.srcFile]; ok {
-			if *verbose {
-				fmt.Printf("%s: Setting title to <%s>\n", p.srcFile, c)
-			}
-			p.MustExif().title = c
+	opts.Progress = false
+	w := os.Stdout
+	if *out == "" {
+		// Verbose output would be mixed with the manifest.
+		opts.Verbose = false
+	} else {
+		if w, err = os.Create(*out); err != nil {
+			return err
 		}
 	}
-}
-
-// buildCaptions will build a map of image filenames to
-// any captions that are defined in the config file.
-func buildCaptions(cl []string, capt map[string]string) {
-	for _, c := range cl {
-		// Caption is of the form <img_file Caption to be added>
-		cimg, caption, found := strings.Cut(c, " ")
-		if found {
-			capt[cimg] = caption
-		}
+	err = builder.ExportManifest(context.Background(), conf, opts, w, strings.EqualFold(filepath.Ext(*out), ".tsv"))
+	if cerr := opts.Cache.Save(); cerr != nil {
+		log.Printf("EXIF cache: %v", cerr)
 	}
-}
-
-// buildRatings builds a map of ratings. The rating is optionally
-// a minimum rating value (if scale is false), so that photos with this rating
-// or higher are selected, or as a list of ratings, and only those photos
-// that have the rating will be selected.
-func buildRatings(ratings []string, ratingMap map[string]struct{}, scale bool) {
-	rList := strings.Fields(ratings[0])
-	if !scale {
-		// Allow all of the listed ratings to be included.
-		for _, r := range rList {
-			ratingMap[r] = struct{}{}
-		}
-	} else {
-		// If rating scale is set, then only allow a single rating value,
-		// which is treated as a minimum rating - any ratings this rating and
-		// higher are included
-		for _, v := range rScaleMap[rList[0]] {
-			ratingMap[v] = struct{}{}
+	if w != os.Stdout {
+		if cerr := w.Close(); err == nil {
+			err = cerr
 		}
 	}
+	return err
 }
 
-func resizePhotos(handler NewImage, picts []*Pict, download int) {
-	resizers := NewWorker(time.Second*time.Duration(*watchdog), "Resizing", len(picts))
-	defer resizers.Wait()
-	for _, p := range picts {
-		resizers.Run(func() {
-			if err := p.Resize(handler, thumbWidth, thumbHeight, previewWidth, previewHeight, imageWidth, imageHeight); err != nil {
-				log.Fatalf("%s: resizing %v", p.srcPath, err)
-			}
-			dlPath := path.Join(p.destDir, p.dlFile)
-			switch download {
-			case DL_STATIC:
-				// If the existing file is a symlink, remove it.
-				if st, err := os.Lstat(dlPath); err == nil {
-					if (st.Mode() & os.ModeSymlink) != 0 {
-						if err := os.Remove(dlPath); err != nil {
-							log.Fatalf("%s: remove dl static %v", dlPath, err)
-						}
-					}
-				}
-				// Copy the original into the download directory.
-				if err := cpFile(p.srcPath, dlPath); err != nil {
-					log.Fatalf("%s: download copy: %v", dlPath, err)
-				}
-			case DL_SYMLINK:
-				// If regular file, remove it.
-				if st, err := os.Lstat(dlPath); err == nil {
-					if (st.Mode() & os.ModeSymlink) == 0 {
-						if err := os.Remove(dlPath); err != nil {
-							log.Fatalf("%s: remove dl symlink %v", dlPath, err)
-						}
-					}
-				}
-				// create symlink in the download directory to the original file, if not already existing
-				if _, err := os.Stat(dlPath); err != nil {
-					if err := os.Symlink(p.srcPath, dlPath); err != nil {
-						log.Fatalf("%s: symlink %v", p.srcFile, err)
-					}
-				}
-			}
-		})
+// pruneCache removes the stale entries from the EXIF cache.
+func pruneCache() error {
+	c, err := builder.OpenCache()
+	if err != nil {
+		return err
 	}
-}
-
-func updateZip(destDir string) {
-	cmd := exec.Command("sh", "-c", fmt.Sprintf("(cd %s; zip -FSq photos.zip *)", destDir))
-	fmt.Println("Updating downloads")
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("update zip: %v", err)
+	removed := c.Prune()
+	if err := c.Save(); err != nil {
+		return err
 	}
+	fmt.Printf("%s: removed %d entries, %d remaining\n", c.File(), removed, c.Len())
+	return nil
 }
 
-func removeUnwanted(destDir string, plist []*Pict) {
-	files := make(map[string]struct{})
-	// Get the list of all files in the thumbnail directory, and
-	// add them to the map.
-	dentries, err := os.ReadDir(path.Join(destDir, "t"))
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return
-		}
-		log.Fatalf("%s/t: %v", destDir, err)
+// parseSize parses a size in bytes, with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
 	}
-	for _, d := range dentries {
-		if !d.IsDir() {
-			files[d.Name()] = struct{}{}
-		}
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
 	}
-	// Remove all pictures from the map that are going to be added
-	for _, p := range plist {
-		delete(files, p.destFile)
+	if mult != 1 {
+		s = s[:len(s)-1]
 	}
-	// The entries remaining are unwanted, so remove them.
-	for k, _ := range files {
-		os.Remove(path.Join(destDir, k))
-		os.Remove(path.Join(destDir, "t", k))
-		os.Remove(path.Join(destDir, "p", k))
-		os.Remove(path.Join(destDir, "d", k))
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size (%s)", s)
 	}
+	return n * mult, nil
 }
 
 func usage() {
-	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] config-file\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [config-file | cache-prune | config --effective [config-file] | manifest export [--out file] [config-file]]\n", os.Args[0])
 	flag.PrintDefaults()
 }
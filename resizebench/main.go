@@ -0,0 +1,123 @@
+// resizebench compares the time taken to resize a set of images to the
+// gallery sizes by scaling each size directly from the original image, and by
+// cascading each size from a larger scaled image.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/pweb/builder"
+	"github.com/aamcrae/pweb/imager"
+)
+
+var imagerName = flag.String("imager", "dis", "Select the image handler")
+var sizeList = flag.String("sizes", "1600x1200,1024x768,640x480,160x160", "Comma separated list of sizes")
+var quality = flag.Int("quality", 90, "JPEG quality of the images written")
+var count = flag.Int("count", 1, "Number of times each image is resized")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] image-file|directory ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	im, err := builder.SelectImager(*imagerName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sizes, err := parseSizes(*sizeList)
+	if err != nil {
+		log.Fatalf("sizes: %v", err)
+	}
+	var files []string
+	for _, a := range flag.Args() {
+		if st, err := os.Stat(a); err != nil {
+			log.Fatalf("%s: %v", a, err)
+		} else if st.IsDir() {
+			fl, err := filepath.Glob(filepath.Join(a, "*.[jJ][pP][gG]"))
+			if err != nil {
+				log.Fatalf("%s: %v", a, err)
+			}
+			files = append(files, fl...)
+		} else {
+			files = append(files, a)
+		}
+	}
+	tmp, err := os.MkdirTemp("", "resizebench")
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmp)
+	var direct, cascade time.Duration
+	for _, f := range files {
+		img, err := im.Open(f)
+		if err != nil {
+			log.Fatalf("%s: %v", f, err)
+		}
+		for range *count {
+			start := time.Now()
+			for i, sz := range sizes {
+				w, h := imager.Fit(img.Width(), img.Height(), sz)
+				scaled, err := img.Scale(w, h)
+				if err != nil {
+					log.Fatalf("%s: %v", f, err)
+				}
+				if err := write(tmp, i, scaled); err != nil {
+					log.Fatalf("%s: %v", f, err)
+				}
+				imager.Close(scaled)
+			}
+			d := time.Since(start)
+			direct += d
+			start = time.Now()
+			err := imager.Cascade(img, sizes, func(i int, scaled imager.Image) error {
+				return write(tmp, i, scaled)
+			})
+			if err != nil {
+				log.Fatalf("%s: %v", f, err)
+			}
+			c := time.Since(start)
+			cascade += c
+			fmt.Printf("%s: %d x %d, direct %v, cascade %v\n", f, img.Width(), img.Height(), d.Round(time.Millisecond), c.Round(time.Millisecond))
+		}
+		imager.Close(img)
+	}
+	n := len(files) * *count
+	if n == 0 {
+		log.Fatalf("No images")
+	}
+	fmt.Printf("Direct:  %v (%.2f images/sec)\n", direct.Round(time.Millisecond), float64(n)/direct.Seconds())
+	fmt.Printf("Cascade: %v (%.2f images/sec)\n", cascade.Round(time.Millisecond), float64(n)/cascade.Seconds())
+	fmt.Printf("Speedup: %.2fx\n", direct.Seconds()/cascade.Seconds())
+}
+
+// write writes the scaled image as a JPEG.
+func write(dir string, i int, img imager.Image) error {
+	return img.Write(filepath.Join(dir, fmt.Sprintf("%d.jpg", i)), time.Now(), *quality, imager.JPEG)
+}
+
+// parseSizes parses a list of sizes of the form WxH.
+func parseSizes(s string) ([]imager.Size, error) {
+	var sizes []imager.Size
+	for _, f := range strings.Split(s, ",") {
+		ws, hs, ok := strings.Cut(strings.TrimSpace(f), "x")
+		w, werr := strconv.Atoi(ws)
+		h, herr := strconv.Atoi(hs)
+		if !ok || werr != nil || herr != nil || w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("bad size (%s)", f)
+		}
+		sizes = append(sizes, imager.Size{Width: w, Height: h})
+	}
+	return sizes, nil
+}
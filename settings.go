@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aamcrae/pweb/builder"
+)
+
+// userConfigFile is the file holding the user's flag defaults, relative to the user's config directory.
+const userConfigFile = "pweb/config"
+
+// flagOrigin records where each flag was set, if not the default.
+var flagOrigin = make(map[string]string)
+
+// readUserConfig sets the flags from the user's config file (usually ~/.config/pweb/config),
+// unless they have been set on the command line. Each line of the file contains a flag name
+// followed by a ':' and the value, e.g "base: /var/www/html/photos". Empty lines
+// and lines starting with '#' are ignored. A missing file is not an error.
+func readUserConfig() error {
+	flag.Visit(func(f *flag.Flag) {
+		flagOrigin[f.Name] = "command line"
+	})
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+	file := filepath.Join(dir, userConfigFile)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+		name, value, ok := strings.Cut(l, ":")
+		if !ok {
+			return fmt.Errorf("%s: line %d, illegal setting", file, i+1)
+		}
+		name = strings.TrimSpace(name)
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: line %d, unknown flag (%s)", file, i+1, name)
+		}
+		if _, ok := flagOrigin[name]; ok {
+			continue
+		}
+		if err := flag.Set(name, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("%s: line %d, %s: %v", file, i+1, name, err)
+		}
+		flagOrigin[name] = fmt.Sprintf("%s:%d", file, i+1)
+	}
+	return nil
+}
+
+// showConfig implements the config command, which prints the gallery configuration
+// after merging the defaults files and imported files, with the origin of each setting,
+// followed by the flag settings.
+func showConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	effective := fs.Bool("effective", false, "Print the merged configuration with the origin of each setting")
+	fs.Parse(args)
+	if !*effective || fs.NArg() > 1 {
+		return fmt.Errorf("usage: %s [flags] config --effective [config-file]", os.Args[0])
+	}
+	confFile := configDefault
+	if fs.NArg() == 1 {
+		confFile = fs.Arg(0)
+	}
+	conf, err := builder.ReadConfig(confFile)
+	if err != nil {
+		return err
+	}
+	// Check the settings are valid.
+	if _, err := builder.ParseConfig(conf); err != nil {
+		if ce, ok := err.(*builder.ConfigError); ok && ce.File == "" {
+			ce.File = confFile
+		}
+		return err
+	}
+	fmt.Printf("# Gallery configuration (%s)\n", confFile)
+	if err := conf.Write(os.Stdout); err != nil {
+		return err
+	}
+	fmt.Printf("\n# Flags\n")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	flag.VisitAll(func(f *flag.Flag) {
+		origin, ok := flagOrigin[f.Name]
+		if !ok {
+			origin = "default"
+		}
+		fmt.Fprintf(tw, "# --%s=%s\t(%s)\n", f.Name, f.Value, origin)
+	})
+	return tw.Flush()
+}
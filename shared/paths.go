@@ -1,5 +1,11 @@
 package shared
 
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
 const albumFileXML = "album.xml"
 const templateAlbumFileXML = "album-template.xml"
 const galleryFileXML = "gallery.xml"
@@ -14,3 +20,29 @@ const AlbumFileMeta = albumFileJSON
 const GalleryFileMeta = galleryFileJSON
 const TemplateAlbumFileMeta = templateAlbumFileJSON
 const TemplateGalleryFileMeta = templateGalleryFileJSON
+
+// Gallery subdirectories for the scaled images.
+const ThumbDir = "t"
+const Thumb2xDir = "t2"
+const PreviewDir = "p"
+const DownloadDir = "d"
+
+// SizeDir returns the gallery subdirectory holding the images scaled to this width.
+func SizeDir(width int) string {
+	return "w" + strconv.Itoa(width)
+}
+
+// FormatFile returns the name of the file holding the image in an additional format (e.g webp).
+func FormatFile(filename, format string) string {
+	return filename + "." + format
+}
+
+// EscapePath escapes a relative file path so that it can be used as a URL, or within a srcset
+// attribute (where commas separate the image candidates).
+func EscapePath(file string) string {
+	segs := strings.Split(file, "/")
+	for i, s := range segs {
+		segs[i] = strings.ReplaceAll(url.PathEscape(s), ",", "%2C")
+	}
+	return strings.Join(segs, "/")
+}
@@ -25,15 +25,19 @@ type AlbumPage struct {
 }
 
 type Gallery struct {
-	XMLName   xml.Name `xml:"gallery" json:"-"`
-	Title     string   `xml:"title,omitempty" json:"title,omitempty"`
-	Back      string   `xml:"back,omitempty" json:"back,omitempty"`
-	Copyright string   `xml:"copyright,omitempty" json:"copyright,omitempty"`
-	Download  string   `xml:"download,omitempty" json:"download,omitempty"`
-	Thumb     Size     `xml:"thumb" json:"thumb"`
-	Preview   Size     `xml:"preview" json:"preview"`
-	Image     Size     `xml:"image" json:"image"`
-	Photos    []Photo  `xml:"photo" json:"photos,omitempty"`
+	XMLName         xml.Name `xml:"gallery" json:"-"`
+	Title           string   `xml:"title,omitempty" json:"title,omitempty"`
+	Back            string   `xml:"back,omitempty" json:"back,omitempty"`
+	Copyright       string   `xml:"copyright,omitempty" json:"copyright,omitempty"`
+	Download        string   `xml:"download,omitempty" json:"download,omitempty"`
+	Thumb           Size     `xml:"thumb" json:"thumb"`
+	Thumb2x         Size     `xml:"thumb2x" json:"thumb2x,omitzero"` // If set, high resolution thumbnails are available
+	Preview         Size     `xml:"preview" json:"preview"`
+	Image           Size     `xml:"image" json:"image"`
+	Sizes           []int    `xml:"size" json:"sizes,omitempty"`                                // Widths of additional scaled images
+	Formats         []string `xml:"format" json:"formats,omitempty"`                            // Additional image formats, in order of preference
+	NoThumbCaptions bool     `xml:"nothumbcaptions,omitempty" json:"nothumbcaptions,omitempty"` // Do not show the titles on the thumbnails
+	Photos          []Photo  `xml:"photo" json:"photos,omitempty"`
 }
 
 type Photo struct {
@@ -49,4 +53,5 @@ type Photo struct {
 	Aperture    string   `xml:"aperture,omitempty" json:"aperture,omitempty"`
 	FocalLength string   `xml:"length,omitempty" json:"length,omitempty"`
 	Download    string   `xml:"download,omitempty" json:"download,omitempty"`
+	Extras      []string `xml:"extra,omitempty" json:"extras,omitempty"` // Additional downloads e.g a paired raw file
 }
@@ -2,6 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
 
 	"syscall/js"
 
@@ -20,15 +23,16 @@ const hSpace = "&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbs
  * Image holds the data for a single photo
  */
 type Image struct {
-	name       string    // Base filename (that may not be unique)
-	filename   string    // Unique filename that may include appended directory names
-	title      string    // Headline or title
-	date       string    // Date photo was taken
-	thumbEntry string    // The HTML used to display the thumbnail
-	imagePage  string    // The HTML used to display the full sized image
-	download   string    // If set, the file for download
+	name       string      // Base filename (that may not be unique)
+	filename   string      // Unique filename that may include appended directory names, escaped for use in URLs
+	title      string      // Headline or title
+	date       string      // Date photo was taken
+	thumbEntry string      // The HTML used to display the thumbnail
+	imagePage  string      // The HTML used to display the full sized image
+	download   string      // If set, the file for download
+	extras     []string    // Additional files for download e.g a paired raw file
 	original   shared.Size // The original image's resolution
-	exposure   string    // EXIF data
+	exposure   string      // EXIF data
 	aperture   string
 	iso        string
 	flen       string
@@ -48,6 +52,9 @@ type Gallery struct {
 	th, tw     int      // Size of thumbnail image
 	pw, ph     int      // Size of preview image
 	iw, ih     int      // Size of full image
+	thumb2x    bool     // High resolution thumbnails are available
+	sizes      []int    // Widths of the additional scaled images
+	formats    []string // Additional image formats, in order of preference
 	rows, cols int      // Number of thumbnail rows and columns being displayed
 	images     []*Image // slice of images in the gallery
 }
@@ -74,26 +81,29 @@ func RunGallery(w *html.Window, gjson []byte) {
 // newGallery creates a new gallery from the data provided.
 func newGallery(d *shared.Gallery, w *html.Window) *Gallery {
 	g := &Gallery{w: w,
-		title: d.Title,
-		back:  d.Back,
-		owner: d.Copyright,
-		tw:    d.Thumb.Width,
-		th:    d.Thumb.Height,
-		pw:    d.Preview.Width,
-		ph:    d.Preview.Height,
-		iw:    d.Image.Width,
-		ih:    d.Image.Height,
+		title:   d.Title,
+		back:    d.Back,
+		owner:   d.Copyright,
+		tw:      d.Thumb.Width,
+		th:      d.Thumb.Height,
+		pw:      d.Preview.Width,
+		ph:      d.Preview.Height,
+		iw:      d.Image.Width,
+		ih:      d.Image.Height,
+		sizes:   d.Sizes,
+		formats: d.Formats,
 	}
+	g.thumb2x = d.Thumb2x.Width != 0
 	if g.title == "" {
 		g.title = "Gallery"
 	}
-	g.header = g.HeaderDownload(g.title, g.back, d.Download)
+	g.header = g.HeaderDownload(g.title, g.back, shared.EscapePath(d.Download))
 	for i, entry := range d.Photos {
 		img := &Image{name: entry.Name,
-			filename: entry.Filename,
+			filename: shared.EscapePath(entry.Filename),
 			title:    entry.Title,
 			date:     entry.Date,
-			download: entry.Download,
+			download: shared.EscapePath(entry.Download),
 			original: entry.Original,
 			aperture: entry.Aperture,
 			exposure: entry.Exposure,
@@ -105,8 +115,11 @@ func newGallery(d *shared.Gallery, w *html.Window) *Gallery {
 				h.Div(h.Class("slideshow"), h.Id(h.Text("slide", i)),
 					h.A(h.Onclick(h.Text("return showPict(", i, ")")),
 						h.Href("#"),
-						h.Img(h.Title(img.title), h.Src(h.Text("t/", img.filename)))),
-					h.Div(h.If(len(img.title) > 0), h.Class("thumbName"), img.title))).String()
+						g.ThumbImg(img)),
+					h.Div(h.If(len(img.title) > 0 && !d.NoThumbCaptions), h.Class("thumbName"), img.title))).String()
+		for _, e := range entry.Extras {
+			img.extras = append(img.extras, shared.EscapePath(e))
+		}
 		g.images = append(g.images, img)
 	}
 	// Install some style elements now that we know the thumbnail sizes
@@ -311,7 +324,7 @@ func (g *Gallery) BuildPict(index int) {
 		t = g.title
 	}
 	h.Wr(g.HeaderDownload(t, "", img.download))
-	h.Wr(h.Div(h.Id("mainimage"), h.Img(h.Src(img.filename), h.Alt(t))))
+	h.Wr(h.Div(h.Id("mainimage"), g.MainImg(img, t)))
 	// Show image properties etc.
 	h.Wr(h.Div(h.Open(), h.Class("properties"), h.Table(h.Open(), h.Summary("image properties"), h.Border(0))))
 	h.Wr(g.Property("Date", img.date))
@@ -323,6 +336,14 @@ func (g *Gallery) BuildPict(index int) {
 	h.Wr(g.Property("Aperture", img.aperture))
 	h.Wr(g.Property("ISO", img.iso))
 	h.Wr(g.Property("Focal length (mm)", img.flen))
+	if len(img.extras) > 0 {
+		var links []string
+		for _, e := range img.extras {
+			name, _ := url.PathUnescape(path.Base(e))
+			links = append(links, h.A(h.Download(), h.Href(e), name).String())
+		}
+		h.Wr(g.Property("Download", strings.Join(links, " ")))
+	}
 	h.Wr(h.Table(h.Close()))
 	h.Wr(h.Div(h.Close()))
 	h.Wr(Copyright(g.owner))
@@ -336,6 +357,101 @@ func (g *Gallery) BuildPict(index int) {
 	img.imagePage = h.String()
 }
 
+// ThumbImg generates the HTML for the thumbnail image, allowing
+// the browser to select the high resolution thumbnail on HiDPI screens,
+// and any additional image formats.
+func (g *Gallery) ThumbImg(img *Image) string {
+	srcset := func(file string) string {
+		if !g.thumb2x {
+			return ""
+		}
+		return shared.ThumbDir + "/" + file + " 1x, " + shared.Thumb2xDir + "/" + file + " 2x"
+	}
+	var sources []string
+	for _, f := range g.formats {
+		sources = append(sources, tag("source", "type", "image/"+f, "srcset", srcset(shared.FormatFile(img.filename, f))))
+	}
+	return picture(sources, tag("img", "title", img.title, "src", shared.ThumbDir+"/"+img.filename, "srcset", srcset(img.filename)))
+}
+
+// MainImg generates the HTML for the full sized image, with a list of the scaled
+// images so that the browser can select the image appropriate to the screen size,
+// and any additional image formats.
+func (g *Gallery) MainImg(img *Image, alt string) string {
+	iw := g.scaledWidth(img, g.iw, g.ih)
+	var sizes string
+	if len(g.sizes) != 0 {
+		sizes = html.NewHTML().Text("(max-width: ", iw, "px) 100vw, ", iw, "px")
+	}
+	var sources []string
+	for _, f := range g.formats {
+		sources = append(sources, tag("source", "type", "image/"+f, "srcset", g.mainSrcset(img, shared.FormatFile(img.filename, f)), "sizes", sizes))
+	}
+	return picture(sources, tag("img", "src", img.filename, "alt", alt, "srcset", g.mainSrcset(img, img.filename), "sizes", sizes))
+}
+
+// mainSrcset generates the list of scaled images and their widths.
+func (g *Gallery) mainSrcset(img *Image, file string) string {
+	if len(g.sizes) == 0 {
+		return file
+	}
+	var set []string
+	last := 0
+	for _, w := range g.sizes {
+		sw := g.scaledWidth(img, w, w*g.ih/g.iw)
+		if sw <= last {
+			// Original is smaller than this size.
+			continue
+		}
+		set = append(set, html.NewHTML().Text(shared.SizeDir(w), "/", file, " ", sw, "w"))
+		last = sw
+	}
+	if iw := g.scaledWidth(img, g.iw, g.ih); iw > last {
+		set = append(set, html.NewHTML().Text(file, " ", iw, "w"))
+	}
+	return strings.Join(set, ", ")
+}
+
+// scaledWidth returns the width of the image when scaled to fit
+// within the width and height.
+func (g *Gallery) scaledWidth(img *Image, w, h int) int {
+	ow, oh := img.original.Width, img.original.Height
+	if ow == 0 || oh == 0 {
+		// Original resolution unknown.
+		return w
+	}
+	if ow <= w && oh <= h {
+		return ow
+	}
+	if float64(w)/float64(ow) < float64(h)/float64(oh) {
+		return w
+	}
+	return int(float64(ow)*float64(h)/float64(oh) + 0.5)
+}
+
+// picture wraps the image in a picture element if there are alternative sources.
+func picture(sources []string, img string) string {
+	if len(sources) == 0 {
+		return img
+	}
+	return "<picture>" + strings.Join(sources, "") + img + "</picture>"
+}
+
+// tag generates an empty element from a list of attribute name and value pairs,
+// used for attributes (such as srcset) not supported by the html package.
+// Attributes with empty values are omitted.
+func tag(name string, attrs ...string) string {
+	var b strings.Builder
+	b.WriteString("<" + name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			b.WriteString(" " + attrs[i] + "=\"" + attrs[i+1] + "\"")
+		}
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
 // Property generates HTML for the image metadata (name, size etc.)
 func (g *Gallery) Property(n, val string) string {
 	h := html.NewHTML()